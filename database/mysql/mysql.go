@@ -1,10 +1,13 @@
 package mysql
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"strconv"
 	"strings"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
 	"github.com/pkg/errors"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -24,6 +27,8 @@ type DBConn struct {
 
 	// Replica configuration list.
 	Replicas []ConnectionConfig `json:"replicas" yaml:"replicas"`
+	// Replica selection policy; defaults to ReplicaPolicyRandom.
+	ReplicaPolicy ReplicaPolicy `json:"replicaPolicy" yaml:"replicaPolicy"`
 
 	// Connection pool settings.
 	MaxIdleConns    int           `json:"maxIdleConns" yaml:"maxIdleConns"`
@@ -49,6 +54,7 @@ type ConnectionConfig struct {
 	Password string        `json:"password" yaml:"password"`
 	Loc      string        `json:"loc" yaml:"loc"`
 	Timeout  time.Duration `json:"timeout" yaml:"timeout"` // Connection timeout.
+	Weight   int           `json:"weight" yaml:"weight"`   // Relative weight for ReplicaPolicyWeighted; defaults to 1.
 }
 
 // DSN generates a MySQL DSN string.
@@ -96,34 +102,69 @@ func (c *ConnectionConfig) DSN(cfg *DBConn) string {
 }
 
 // New creates a new database connection with read/write splitting.
+// Closing the *sql.DB returned by DB() also stops replica latency probing and
+// closes the replica connections.
 func New(conn *DBConn) (*gorm.DB, error) {
 	if conn.Database == "" {
 		return nil, errors.New("database name is required")
 	}
 
+	policy, err := newPolicy(conn)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create primary connection.
 	masterDSN := conn.Master.DSN(conn)
-	dbBase, err := gorm.Open(mysql.Open(masterDSN), &gorm.Config{})
+	masterConfig, err := mysqldriver.ParseDSN(masterDSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse master DSN")
+	}
+	connector, err := mysqldriver.NewConnector(masterConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create master connector")
+	}
+	closer := &closeConnector{Connector: connector}
+	masterDB := sql.OpenDB(closer)
+	dbBase, err := gorm.Open(mysql.New(mysql.Config{DSN: masterDSN, Conn: masterDB}), &gorm.Config{})
 	if err != nil {
+		_ = masterDB.Close()
 		return nil, errors.Wrapf(err, "open database connection: %s", masterDSN)
 	}
 
 	// Configure read/write splitting when replicas are provided.
 	if len(conn.Replicas) > 0 {
-		var replicas []gorm.Dialector
+		var (
+			replicas   []gorm.Dialector
+			replicaDBs []*sql.DB
+		)
 		for _, replica := range conn.Replicas {
 			replicaDSN := replica.DSN(conn)
-			replicas = append(replicas, mysql.Open(replicaDSN))
+			replicaDB, err := sql.Open(mysql.DefaultDriverName, replicaDSN)
+			if err != nil {
+				_ = masterDB.Close()
+				return nil, errors.Wrap(err, "failed to open replica")
+			}
+			closer.closers = append(closer.closers, replicaDB.Close)
+			replicaDBs = append(replicaDBs, replicaDB)
+			replicas = append(replicas, mysql.New(mysql.Config{DSN: replicaDSN, Conn: replicaDB}))
 		}
 
 		// Register dbresolver plugin.
 		err = dbBase.Use(dbresolver.Register(dbresolver.Config{
 			Replicas: replicas,
-			Policy:   dbresolver.RandomPolicy{},
+			Policy:   policy,
 		}))
 		if err != nil {
+			_ = masterDB.Close()
 			return nil, errors.Wrap(err, "failed to register dbresolver")
 		}
+
+		if latency, ok := policy.(*latencyPolicy); ok {
+			latency.start(replicaDBs)
+			// Stop probing before the replicas are closed.
+			closer.closers = append([]func() error{latency.close}, closer.closers...)
+		}
 	}
 
 	// Get underlying SQL DB object to configure pool settings.
@@ -154,3 +195,21 @@ func New(conn *DBConn) (*gorm.DB, error) {
 
 	return dbBase, nil
 }
+
+// closeConnector runs closers when the database/sql handle built on it is closed,
+// releasing the replicas and background work tied to the master.
+type closeConnector struct {
+	driver.Connector
+	closers []func() error
+}
+
+// Close implements io.Closer, which sql.DB.Close calls after closing its own connections.
+func (c *closeConnector) Close() error {
+	var err error
+	for _, closer := range c.closers {
+		if closeErr := closer(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
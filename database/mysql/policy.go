@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ReplicaPolicy selects how reads are balanced across replicas.
+type ReplicaPolicy string
+
+const (
+	ReplicaPolicyRandom     ReplicaPolicy = "random"
+	ReplicaPolicyRoundRobin ReplicaPolicy = "round_robin"
+	// Picks replicas proportionally to ConnectionConfig.Weight.
+	ReplicaPolicyWeighted ReplicaPolicy = "weighted"
+	// Picks the replica with the lowest ping time, measured when the DB is
+	// created and every 10s until it is closed.
+	ReplicaPolicyLeastLatency ReplicaPolicy = "least_latency"
+)
+
+const (
+	_latencyProbeInterval = 10 * time.Second
+	_latencyProbeTimeout  = 5 * time.Second
+)
+
+// newPolicy creates the dbresolver policy for the configured replicas.
+func newPolicy(conn *DBConn) (dbresolver.Policy, error) {
+	switch conn.ReplicaPolicy {
+	case "", ReplicaPolicyRandom:
+		return dbresolver.RandomPolicy{}, nil
+	case ReplicaPolicyRoundRobin:
+		return dbresolver.StrictRoundRobinPolicy(), nil
+	case ReplicaPolicyWeighted:
+		weights := make([]int, len(conn.Replicas))
+		for i, replica := range conn.Replicas {
+			weights[i] = max(replica.Weight, 1)
+		}
+		return &weightedPolicy{weights: weights}, nil
+	case ReplicaPolicyLeastLatency:
+		return &latencyPolicy{latencies: make(map[gorm.ConnPool]time.Duration)}, nil
+	default:
+		return nil, errors.Errorf("unknown replica policy %q", conn.ReplicaPolicy)
+	}
+}
+
+// weightedPolicy picks replicas proportionally to their weights.
+// dbresolver passes replicas in configuration order, so weights are matched by index.
+type weightedPolicy struct {
+	weights []int
+}
+
+func (p *weightedPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	total := 0
+	for i := range connPools {
+		total += p.weight(i)
+	}
+
+	n := rand.IntN(total)
+	for i, connPool := range connPools {
+		if n < p.weight(i) {
+			return connPool
+		}
+		n -= p.weight(i)
+	}
+	return connPools[len(connPools)-1]
+}
+
+func (p *weightedPolicy) weight(i int) int {
+	if i < len(p.weights) {
+		return p.weights[i]
+	}
+	return 1
+}
+
+// latencyPolicy picks the replica with the lowest ping time. Ping times are
+// measured when the DB is created and refreshed in the background until it is closed.
+type latencyPolicy struct {
+	mu        sync.RWMutex
+	latencies map[gorm.ConnPool]time.Duration
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+func (p *latencyPolicy) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	best := connPools[0]
+	for _, connPool := range connPools[1:] {
+		if p.latencies[connPool] < p.latencies[best] {
+			best = connPool
+		}
+	}
+	return best
+}
+
+// start measures the replicas once, then keeps refreshing them until close.
+func (p *latencyPolicy) start(replicas []*sql.DB) {
+	p.probe(context.Background(), replicas)
+
+	ctx, stop := context.WithCancel(context.Background())
+	p.stop = stop
+	p.done = make(chan struct{})
+	go p.run(ctx, replicas)
+}
+
+func (p *latencyPolicy) run(ctx context.Context, replicas []*sql.DB) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(_latencyProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probe(ctx, replicas)
+		}
+	}
+}
+
+// close stops probing and waits for a running probe to return.
+func (p *latencyPolicy) close() error {
+	if p.stop != nil {
+		p.stop()
+		<-p.done
+	}
+	return nil
+}
+
+// probe pings every replica concurrently, each for at most the probe timeout.
+func (p *latencyPolicy) probe(ctx context.Context, replicas []*sql.DB) {
+	results := make([]time.Duration, len(replicas))
+	var wg sync.WaitGroup
+	for i, replica := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, _latencyProbeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			if err := replica.PingContext(ctx); err != nil {
+				// Push unreachable replicas to the back of the line.
+				results[i] = _latencyProbeTimeout
+			} else {
+				results[i] = time.Since(start)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	latencies := make(map[gorm.ConnPool]time.Duration, len(replicas))
+	for i, replica := range replicas {
+		latencies[replica] = results[i]
+	}
+	p.mu.Lock()
+	p.latencies = latencies
+	p.mu.Unlock()
+}
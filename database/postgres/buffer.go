@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// _bufferedDB replays buffered results as *sql.Rows and *sql.Row, which
+// database/sql only creates from a driver.
+var _bufferedDB = sql.OpenDB(bufferedConnector{})
+
+// bufferedConnPool reads every row of a query before returning it, so the
+// transaction it runs in can end before the caller reads the rows.
+type bufferedConnPool struct {
+	gorm.ConnPool
+	tx gorm.TxCommitter
+}
+
+func (p *bufferedConnPool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	result := p.query(ctx, query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return _bufferedDB.QueryContext(ctx, "", result)
+}
+
+func (p *bufferedConnPool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return _bufferedDB.QueryRowContext(ctx, "", p.query(ctx, query, args))
+}
+
+func (p *bufferedConnPool) Commit() error {
+	return p.tx.Commit()
+}
+
+func (p *bufferedConnPool) Rollback() error {
+	return p.tx.Rollback()
+}
+
+func (p *bufferedConnPool) query(ctx context.Context, query string, args []any) *bufferedResult {
+	rows, err := p.ConnPool.QueryContext(ctx, query, args...)
+	if err != nil {
+		return &bufferedResult{err: err}
+	}
+	defer rows.Close()
+
+	result := &bufferedResult{}
+	if result.columns, err = rows.ColumnTypes(); err != nil {
+		return &bufferedResult{err: err}
+	}
+	for rows.Next() {
+		values := make([]any, len(result.columns))
+		dest := make([]any, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return &bufferedResult{err: err}
+		}
+		result.rows = append(result.rows, values)
+	}
+	if err := rows.Err(); err != nil {
+		return &bufferedResult{err: err}
+	}
+	return result
+}
+
+// bufferedResult is a query result read into memory, or the error of the query.
+type bufferedResult struct {
+	columns []*sql.ColumnType
+	rows    [][]any
+	err     error
+}
+
+type bufferedConnector struct{}
+
+func (bufferedConnector) Connect(context.Context) (driver.Conn, error) {
+	return bufferedConn{}, nil
+}
+
+func (bufferedConnector) Driver() driver.Driver {
+	return bufferedDriver{}
+}
+
+type bufferedDriver struct{}
+
+func (bufferedDriver) Open(string) (driver.Conn, error) {
+	return bufferedConn{}, nil
+}
+
+// bufferedConn serves the *bufferedResult passed as the only query argument.
+type bufferedConn struct{}
+
+func (bufferedConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("buffered results cannot be prepared")
+}
+
+func (bufferedConn) Close() error {
+	return nil
+}
+
+func (bufferedConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("buffered results have no transactions")
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, passing the result through unconverted.
+func (bufferedConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (bufferedConn) QueryContext(_ context.Context, _ string, args []driver.NamedValue) (driver.Rows, error) {
+	if len(args) != 1 {
+		return nil, errors.New("buffered query takes a single result")
+	}
+	result, ok := args[0].Value.(*bufferedResult)
+	if !ok {
+		return nil, errors.Errorf("unexpected buffered query argument %T", args[0].Value)
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &bufferedRows{result: result}, nil
+}
+
+// bufferedRows replays a result, including the column types GORM uses to scan.
+type bufferedRows struct {
+	result *bufferedResult
+	next   int
+}
+
+func (r *bufferedRows) Columns() []string {
+	names := make([]string, len(r.result.columns))
+	for i, column := range r.result.columns {
+		names[i] = column.Name()
+	}
+	return names
+}
+
+func (r *bufferedRows) Close() error {
+	return nil
+}
+
+func (r *bufferedRows) Next(dest []driver.Value) error {
+	if r.next == len(r.result.rows) {
+		return io.EOF
+	}
+	for i, value := range r.result.rows[r.next] {
+		dest[i] = value
+	}
+	r.next++
+	return nil
+}
+
+func (r *bufferedRows) ColumnTypeDatabaseTypeName(index int) string {
+	return r.result.columns[index].DatabaseTypeName()
+}
+
+func (r *bufferedRows) ColumnTypeScanType(index int) reflect.Type {
+	return r.result.columns[index].ScanType()
+}
+
+func (r *bufferedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.result.columns[index].Nullable()
+}
+
+func (r *bufferedRows) ColumnTypeLength(index int) (length int64, ok bool) {
+	return r.result.columns[index].Length()
+}
+
+func (r *bufferedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	return r.result.columns[index].DecimalSize()
+}
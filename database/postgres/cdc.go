@@ -0,0 +1,349 @@
+package postgres
+
+import (
+	"context"
+	"encoding/binary"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/pkg/errors"
+)
+
+const _defaultStatusInterval = 10 * time.Second
+
+// Slot and publication names are limited to what replication commands accept unquoted.
+var _replicationName = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// ChangeKind is the kind of a row change.
+type ChangeKind string
+
+const (
+	ChangeInsert ChangeKind = "insert"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+)
+
+// ChangeEvent is a row change decoded from the replication stream.
+// Values are decoded into the Go types pgx uses; unknown types are strings.
+type ChangeEvent struct {
+	Kind   ChangeKind
+	Schema string
+	Table  string
+	// Row after an insert or update. Unchanged TOAST columns are left out.
+	New map[string]any
+	// Replica identity columns before an update or delete; every column with
+	// REPLICA IDENTITY FULL. Nil for updates that did not change the key.
+	Old map[string]any
+
+	LSN        LSN
+	XID        uint32
+	CommitTime time.Time
+}
+
+// ReplicationLag reports how far the consumer is behind the server.
+type ReplicationLag struct {
+	Slot string
+	// Last LSN acknowledged to the server.
+	Confirmed LSN
+	// Current end of the server's WAL.
+	ServerWALEnd LSN
+	// WAL bytes the slot retains for this consumer.
+	Bytes int64
+}
+
+// ChangeConsumerConfig defines the slot, publication and handlers of a ChangeConsumer.
+type ChangeConsumerConfig struct {
+	// Replication slot, created when missing.
+	Slot string
+	// TemporarySlot creates a slot dropped when the consumer disconnects.
+	TemporarySlot bool
+	// Publication, created when missing.
+	Publication string
+	// Tables ("table" or "schema.table") of a created publication; empty publishes all tables.
+	Tables []string
+
+	// Handler receives the changes of each committed transaction, in commit order.
+	// The transaction is acknowledged only after Handler returns nil; an error stops
+	// the consumer, and the transaction is delivered again on the next run.
+	Handler func(ctx context.Context, events []ChangeEvent) error
+	// OnLag is called every StatusInterval.
+	OnLag func(ReplicationLag)
+	// Interval of standby status updates; defaults to 10s.
+	StatusInterval time.Duration
+}
+
+// ChangeConsumer streams row changes from a logical replication slot using the
+// pgoutput plugin. The server needs wal_level=logical and the user the
+// REPLICATION attribute.
+type ChangeConsumer struct {
+	cfg        ChangeConsumerConfig
+	connConfig *pgx.ConnConfig
+	credential CredentialProvider
+}
+
+// NewChangeConsumer creates a consumer connecting to the master of conn.
+func NewChangeConsumer(conn *DBConn, cfg ChangeConsumerConfig) (*ChangeConsumer, error) {
+	if !_replicationName.MatchString(cfg.Slot) {
+		return nil, errors.Errorf("invalid replication slot name %q", cfg.Slot)
+	}
+	if !_replicationName.MatchString(cfg.Publication) {
+		return nil, errors.Errorf("invalid publication name %q", cfg.Publication)
+	}
+	if cfg.Handler == nil {
+		return nil, errors.New("change handler is required")
+	}
+
+	preset, err := resolvePreset(conn.Preset)
+	if err != nil {
+		return nil, err
+	}
+	if preset.TransactionPooling {
+		return nil, errors.Errorf("logical replication is not supported with preset %q", conn.Preset)
+	}
+
+	poolConfig, _, err := newPoolConfig(conn.Master.withTargetSessionAttrs("read-write"), conn, preset, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse replication config")
+	}
+	poolConfig.ConnConfig.RuntimeParams["replication"] = "database"
+
+	return &ChangeConsumer{
+		cfg:        cfg,
+		connConfig: poolConfig.ConnConfig,
+		credential: conn.CredentialProvider,
+	}, nil
+}
+
+// Run connects, prepares the publication and slot, and streams changes until
+// ctx is done or the handler fails. It returns nil when ctx is done.
+func (c *ChangeConsumer) Run(ctx context.Context) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close(context.Background()) }()
+
+	if err := c.setup(ctx, conn); err != nil {
+		return err
+	}
+	if err := c.startReplication(ctx, conn); err != nil {
+		return err
+	}
+
+	err = c.stream(ctx, conn)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (c *ChangeConsumer) connect(ctx context.Context) (*pgconn.PgConn, error) {
+	connConfig := c.connConfig.Copy()
+	if c.credential != nil {
+		if err := applyCredentials(ctx, connConfig, c.credential); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := pgconn.ConnectConfig(ctx, &connConfig.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect replication")
+	}
+	return conn, nil
+}
+
+// setup creates the publication and slot when they do not exist yet.
+func (c *ChangeConsumer) setup(ctx context.Context, conn *pgconn.PgConn) error {
+	exists, err := queryExists(ctx, conn, "SELECT 1 FROM pg_publication WHERE pubname = "+quoteLiteral(c.cfg.Publication))
+	if err != nil {
+		return errors.Wrap(err, "failed to check publication")
+	}
+	if !exists {
+		target := "ALL TABLES"
+		if len(c.cfg.Tables) > 0 {
+			tables := make([]string, len(c.cfg.Tables))
+			for i, table := range c.cfg.Tables {
+				tables[i] = pgx.Identifier(strings.Split(table, ".")).Sanitize()
+			}
+			target = "TABLE " + strings.Join(tables, ", ")
+		}
+		if _, err := conn.Exec(ctx, "CREATE PUBLICATION "+c.cfg.Publication+" FOR "+target).ReadAll(); err != nil {
+			return errors.Wrap(err, "failed to create publication")
+		}
+	}
+
+	if !c.cfg.TemporarySlot {
+		exists, err := queryExists(ctx, conn, "SELECT 1 FROM pg_replication_slots WHERE slot_name = "+quoteLiteral(c.cfg.Slot))
+		if err != nil {
+			return errors.Wrap(err, "failed to check replication slot")
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	temporary := ""
+	if c.cfg.TemporarySlot {
+		temporary = " TEMPORARY"
+	}
+	if _, err := conn.Exec(ctx, "CREATE_REPLICATION_SLOT "+c.cfg.Slot+temporary+" LOGICAL pgoutput NOEXPORT_SNAPSHOT").ReadAll(); err != nil {
+		return errors.Wrap(err, "failed to create replication slot")
+	}
+	return nil
+}
+
+// startReplication switches the connection into streaming mode from the slot's
+// confirmed position.
+func (c *ChangeConsumer) startReplication(ctx context.Context, conn *pgconn.PgConn) error {
+	sql := "START_REPLICATION SLOT " + c.cfg.Slot + " LOGICAL 0/0 (proto_version '1', publication_names " + quoteLiteral(c.cfg.Publication) + ")"
+	conn.Frontend().Send(&pgproto3.Query{String: sql})
+	if err := conn.Frontend().Flush(); err != nil {
+		return errors.Wrap(err, "failed to start replication")
+	}
+
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to start replication")
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return errors.Wrap(pgconn.ErrorResponseToPgError(msg), "failed to start replication")
+		}
+	}
+}
+
+func (c *ChangeConsumer) stream(ctx context.Context, conn *pgconn.PgConn) error {
+	interval := firstPositive(c.cfg.StatusInterval, _defaultStatusInterval)
+	decoder := newPgoutputDecoder()
+
+	var pos streamPosition
+	nextStatus := time.Now().Add(interval)
+	for {
+		if !time.Now().Before(nextStatus) {
+			if err := sendStandbyStatus(conn, pos.confirmed); err != nil {
+				return err
+			}
+			if c.cfg.OnLag != nil {
+				c.cfg.OnLag(ReplicationLag{
+					Slot:         c.cfg.Slot,
+					Confirmed:    pos.confirmed,
+					ServerWALEnd: pos.serverWALEnd,
+					Bytes:        int64(max(pos.serverWALEnd, pos.confirmed) - pos.confirmed),
+				})
+			}
+			nextStatus = time.Now().Add(interval)
+		}
+
+		receiveCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		msg, err := conn.ReceiveMessage(receiveCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() == nil && pgconn.Timeout(err) {
+				continue
+			}
+			return errors.Wrap(err, "failed to receive replication message")
+		}
+
+		var data []byte
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			data = msg.Data
+		case *pgproto3.ErrorResponse:
+			return errors.Wrap(pgconn.ErrorResponseToPgError(msg), "replication failed")
+		default:
+			continue
+		}
+
+		r := &messageReader{data: data}
+		switch r.byte() {
+		case 'k': // primary keepalive
+			replyRequested, err := pos.keepalive(r, decoder.inTx)
+			if err != nil {
+				return err
+			}
+			if replyRequested {
+				if err := sendStandbyStatus(conn, pos.confirmed); err != nil {
+					return err
+				}
+			}
+		case 'w': // WAL data
+			walStart := LSN(r.int64())
+			pos.serverWALEnd = max(pos.serverWALEnd, LSN(r.int64()))
+			r.int64() // server time
+			if r.err != nil {
+				return errors.Wrap(r.err, "failed to decode WAL data")
+			}
+
+			events, commitLSN, committed, err := decoder.decode(r.data, walStart)
+			if err != nil {
+				return err
+			}
+			if !committed {
+				continue
+			}
+			if len(events) > 0 {
+				if err := c.cfg.Handler(ctx, events); err != nil {
+					return errors.Wrap(err, "failed to handle changes")
+				}
+			}
+			pos.confirmed = max(pos.confirmed, commitLSN)
+		}
+	}
+}
+
+// streamPosition tracks the LSN acknowledged to the server and the server's WAL end.
+type streamPosition struct {
+	confirmed    LSN
+	serverWALEnd LSN
+}
+
+// keepalive reads a primary keepalive message and reports whether the server
+// requested a reply.
+func (p *streamPosition) keepalive(r *messageReader, inTx bool) (bool, error) {
+	walEnd := LSN(r.int64())
+	r.int64() // server time
+	replyRequested := r.byte() == 1
+	if r.err != nil {
+		return false, errors.Wrap(r.err, "failed to decode keepalive")
+	}
+	p.serverWALEnd = max(p.serverWALEnd, walEnd)
+	// Nothing is pending between transactions, so skipped WAL can be released.
+	if !inTx {
+		p.confirmed = max(p.confirmed, walEnd)
+	}
+	return replyRequested, nil
+}
+
+// sendStandbyStatus acknowledges lsn as written, flushed and applied.
+func sendStandbyStatus(conn *pgconn.PgConn, lsn LSN) error {
+	data := make([]byte, 0, 34)
+	data = append(data, 'r')
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn))
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn))
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn))
+	data = binary.BigEndian.AppendUint64(data, uint64(pgMicros(time.Now())))
+	data = append(data, 0) // no reply requested
+
+	conn.Frontend().Send(&pgproto3.CopyData{Data: data})
+	return errors.Wrap(conn.Frontend().Flush(), "failed to send standby status")
+}
+
+func queryExists(ctx context.Context, conn *pgconn.PgConn, sql string) (bool, error) {
+	results, err := conn.Exec(ctx, sql).ReadAll()
+	if err != nil {
+		return false, err
+	}
+	return len(results) > 0 && len(results[0].Rows) > 0, nil
+}
+
+// quoteLiteral quotes s as a SQL string literal for the simple query protocol.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
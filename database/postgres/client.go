@@ -0,0 +1,348 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// Client holds the native pgx pools together with the GORM DB built on top of them.
+// Both share the same physical connections.
+type Client struct {
+	// GORM DB with read/write splitting when replicas are configured.
+	DB *gorm.DB
+	// Native pgx pool for the primary.
+	Master *pgxpool.Pool
+	// Native pgx pools for the replicas, in DBConn.EffectiveReplicas order.
+	Replicas []*pgxpool.Pool
+
+	endpoints []endpoint
+
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewClient creates a new PostgreSQL client exposing both pgx pools and a GORM DB.
+// Call Close to stop background checks and close the pools.
+func NewClient(conn *DBConn) (*Client, error) {
+	return newClient(conn, false)
+}
+
+// newClient creates the client. With closeWithDB, closing the database/sql
+// handle of the GORM DB closes the whole client.
+func newClient(conn *DBConn, closeWithDB bool) (*Client, error) {
+	preset, err := resolvePreset(conn.Preset)
+	if err != nil {
+		return nil, err
+	}
+	if conn.Consistency != nil {
+		if err := conn.Consistency.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	replicaConns := conn.EffectiveReplicas()
+	client, err := newClientPools(conn, replicaConns, preset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify before GORM opens the pools, so failures are reported per endpoint.
+	if conn.Verify != nil {
+		if err := client.Verify(context.Background(), *conn.Verify); err != nil {
+			client.closePools()
+			return nil, err
+		}
+	}
+
+	// Apply GORM settings.
+	gormConfig := &gorm.Config{}
+	applyGORMConfig(gormConfig, conn, preset)
+	masterDB := stdlib.OpenDBFromPool(client.Master)
+	if closeWithDB {
+		masterDB = sql.OpenDB(&closeConnector{Connector: stdlib.GetPoolConnector(client.Master), client: client})
+		// Connections are managed by pgxpool, as with OpenDBFromPool.
+		masterDB.SetMaxIdleConns(0)
+	}
+	client.endpoints[0].db = masterDB
+	dbBase, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: masterDB,
+	}), gormConfig)
+	if err != nil {
+		client.closePools()
+		return nil, errors.Wrap(err, "failed to create master connection")
+	}
+	client.DB = dbBase
+	if err := dbBase.Use(&clientPlugin{conn: conn, preset: preset}); err != nil {
+		client.closePools()
+		return nil, errors.Wrap(err, "failed to register client plugin")
+	}
+
+	// Configure read/write splitting when replicas are provided.
+	var replicaRouter *router
+	if len(client.Replicas) > 0 {
+		replicaRouter, err = newRouter(masterDB, conn.ReplicaPolicy)
+		if err != nil {
+			client.closePools()
+			return nil, err
+		}
+		replicaRouter.consistency = conn.Consistency
+		replicaRouter.masterPool = client.Master
+
+		var replicas []gorm.Dialector
+		for i, replicaPool := range client.Replicas {
+			replicaDB := stdlib.OpenDBFromPool(replicaPool)
+			client.endpoints[i+1].db = replicaDB
+			replicaRouter.addReplica(replicaConns[i], replicaPool, replicaDB)
+			replicas = append(replicas, postgres.New(postgres.Config{
+				Conn: replicaDB,
+			}))
+		}
+
+		// Register dbresolver plugin.
+		err = dbBase.Use(dbresolver.Register(dbresolver.Config{
+			Replicas: replicas,
+			Policy:   replicaRouter,
+		}))
+		if err != nil {
+			client.closePools()
+			return nil, errors.Wrap(err, "failed to register dbresolver")
+		}
+		if err := replicaRouter.register(dbBase); err != nil {
+			client.closePools()
+			return nil, errors.Wrap(err, "failed to register replica router")
+		}
+	}
+
+	// Registered after the replica router, so the local transaction uses the chosen pool.
+	if err := registerLocalScope(dbBase, conn.Tenant); err != nil {
+		client.closePools()
+		return nil, errors.Wrap(err, "failed to register local settings")
+	}
+	if conn.QueryComment != nil {
+		if err := registerQueryComment(dbBase, conn.QueryComment, conn.ApplicationName); err != nil {
+			client.closePools()
+			return nil, errors.Wrap(err, "failed to register query comment")
+		}
+	}
+
+	healthCheckPeriod := conn.HealthCheckPeriod
+	if healthCheckPeriod == 0 && replicaRouter != nil && replicaRouter.policy == ReplicaPolicyLeastLatency {
+		healthCheckPeriod = _defaultLatencyCheckPeriod
+	}
+	if healthCheckPeriod > 0 {
+		checker := &healthChecker{
+			period:        healthCheckPeriod,
+			master:        client.Master,
+			masterHost:    conn.Master.address(),
+			masterHealthy: true,
+			router:        replicaRouter,
+			notify:        conn.OnHealthChange,
+		}
+		if replicaRouter != nil && replicaRouter.policy == ReplicaPolicyLeastLatency {
+			// Measure ping times before the first read is routed.
+			checker.check(context.Background())
+		}
+		client.startBackground(checker.run)
+	}
+
+	trackLSN := conn.Consistency != nil && conn.Consistency.TrackLSN
+	if (conn.MaxReplicationLag > 0 || trackLSN) && replicaRouter != nil {
+		period := _defaultLagCheckPeriod
+		if conn.ReplicationLagCheckPeriod > 0 {
+			period = conn.ReplicationLagCheckPeriod
+		}
+		checker := &lagChecker{
+			period: period,
+			maxLag: conn.MaxReplicationLag,
+			router: replicaRouter,
+			notify: conn.OnHealthChange,
+		}
+		client.startBackground(checker.run)
+	}
+
+	return client, nil
+}
+
+// newClientPools creates the pgx pools of the master and every replica.
+// Pools connect lazily, so no connection is made yet.
+func newClientPools(conn *DBConn, replicaConns []ConnectionConfig, preset *PresetConfig) (*Client, error) {
+	masterConfig, masterConns, err := newPoolConfig(conn.Master.withTargetSessionAttrs("read-write"), conn, preset, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse master config")
+	}
+
+	masterPool, err := pgxpool.NewWithConfig(context.Background(), masterConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create master pool")
+	}
+	client := &Client{Master: masterPool}
+	client.endpoints = append(client.endpoints, endpoint{role: RoleMaster, index: -1, host: conn.Master.address(), pool: masterPool, conns: masterConns})
+
+	for i, replica := range replicaConns {
+		replicaConfig, replicaConns, err := newPoolConfig(replica.withTargetSessionAttrs("prefer-standby"), conn, preset, conn.ReplicaPool)
+		if err != nil {
+			client.closePools()
+			return nil, errors.Wrap(err, "failed to parse replica config")
+		}
+
+		replicaPool, err := pgxpool.NewWithConfig(context.Background(), replicaConfig)
+		if err != nil {
+			client.closePools()
+			return nil, errors.Wrap(err, "failed to create replica pool")
+		}
+		client.Replicas = append(client.Replicas, replicaPool)
+		client.endpoints = append(client.endpoints, endpoint{role: RoleReplica, index: i, host: replica.address(), pool: replicaPool, conns: replicaConns})
+	}
+
+	return client, nil
+}
+
+// startBackground runs fn in the background until the client is closed.
+func (c *Client) startBackground(fn func(context.Context)) {
+	if c.stop == nil {
+		var ctx context.Context
+		ctx, c.stop = context.WithCancel(context.Background())
+		c.ctx = ctx
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		fn(c.ctx)
+	}()
+}
+
+// stopBackground stops background work and waits for it to return.
+func (c *Client) stopBackground() {
+	if c.stop != nil {
+		c.stop()
+		c.wg.Wait()
+	}
+}
+
+// closePools stops background work and closes every pgx pool owned by the client,
+// waiting for acquired connections to be released.
+func (c *Client) closePools() {
+	c.stopBackground()
+	for _, ep := range c.endpoints {
+		ep.pool.Close()
+	}
+}
+
+// closeConnector closes the client when the database/sql handle built on it is
+// closed, so the DB returned by New releases everything on sql.DB.Close.
+type closeConnector struct {
+	driver.Connector
+	client *Client
+}
+
+// Close implements io.Closer, which sql.DB.Close calls after closing its own connections.
+func (c *closeConnector) Close() error {
+	for _, ep := range c.client.endpoints[1:] {
+		if ep.db != nil {
+			_ = ep.db.Close()
+		}
+	}
+	c.client.closePools()
+	return nil
+}
+
+// newPoolConfig builds the pgx pool configuration for a single endpoint, with
+// the tracker of the connections of the pool created from it.
+// rolePool holds pool settings shared by every endpoint of the same role.
+func newPoolConfig(cc ConnectionConfig, conn *DBConn, preset *PresetConfig, rolePool *PoolConfig) (*pgxpool.Config, *connTracker, error) {
+	cfg, err := pgxpool.ParseConfig(cc.DSN(conn))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Set pgx-specific settings.
+	if conn.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = conn.HealthCheckPeriod
+	}
+
+	if err := applyTLSConfig(cfg.ConnConfig, conn.TLS, conn.SSLMode); err != nil {
+		return nil, nil, err
+	}
+
+	if conn.CredentialProvider != nil {
+		cfg.BeforeConnect = beforeConnect(conn.CredentialProvider)
+	}
+
+	// Apply PGX settings.
+	applyPGXConfig(cfg.ConnConfig, conn, preset)
+	cfg.ConnConfig.Tracer = newTracer(conn.Tracers)
+	pool := resolvePoolConfig(conn, preset, cc.Pool, rolePool)
+	applyPoolConfig(cfg, pool)
+	// pgxpool has no cap on idle connections, so the tracker closes released
+	// connections beyond MaxIdleConns.
+	conns := newConnTracker(cfg, pool.MaxIdleConns)
+
+	return cfg, conns, nil
+}
+
+// resolvePoolConfig returns the pool settings of an endpoint. Each field is taken
+// from the first layer that sets it, then from the DBConn settings, preset
+// defaults and package defaults.
+//
+// Settings are applied to the pgx pools rather than through dbresolver, because
+// the database/sql handles dbresolver configures only borrow connections from
+// the pgx pools and keep none idle.
+func resolvePoolConfig(conn *DBConn, preset *PresetConfig, layers ...*PoolConfig) PoolConfig {
+	layers = append(layers, &PoolConfig{
+		MaxOpenConns:    conn.MaxOpenConns,
+		MaxIdleConns:    conn.MaxIdleConns,
+		MinIdleConns:    conn.MinIdleConns,
+		ConnMaxLifetime: conn.ConnMaxLifetime,
+		ConnMaxIdleTime: conn.ConnMaxIdleTime,
+	}, &preset.Pool, &PoolConfig{
+		MaxOpenConns:    _defaultMaxOpenConns,
+		MaxIdleConns:    _defaultMaxIdleConns,
+		ConnMaxLifetime: _defaultMaxLifeTime,
+		ConnMaxIdleTime: _defaultMaxIdleTime,
+	})
+
+	var pool PoolConfig
+	for _, layer := range layers {
+		if layer == nil {
+			continue
+		}
+		pool.MaxOpenConns = firstPositive(pool.MaxOpenConns, layer.MaxOpenConns)
+		pool.MaxIdleConns = firstPositive(pool.MaxIdleConns, layer.MaxIdleConns)
+		pool.MinIdleConns = firstPositive(pool.MinIdleConns, layer.MinIdleConns)
+		pool.ConnMaxLifetime = firstPositive(pool.ConnMaxLifetime, layer.ConnMaxLifetime)
+		pool.ConnMaxIdleTime = firstPositive(pool.ConnMaxIdleTime, layer.ConnMaxIdleTime)
+	}
+	return pool
+}
+
+// applyPoolConfig applies the pool settings pgxpool supports itself.
+func applyPoolConfig(cfg *pgxpool.Config, pool PoolConfig) {
+	cfg.MaxConns = int32(pool.MaxOpenConns)
+	cfg.MaxConnLifetime = pool.ConnMaxLifetime
+	cfg.MaxConnIdleTime = pool.ConnMaxIdleTime
+	if pool.MinIdleConns > 0 {
+		cfg.MinIdleConns = int32(min(pool.MinIdleConns, pool.MaxIdleConns, pool.MaxOpenConns))
+	}
+}
+
+// firstPositive returns the first value greater than zero, or zero.
+func firstPositive[T int | time.Duration](values ...T) T {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
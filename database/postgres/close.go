@@ -0,0 +1,171 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// CloseError lists every endpoint that failed to close cleanly.
+type CloseError struct {
+	Endpoints []*EndpointError
+}
+
+func (e *CloseError) Error() string {
+	return "failed to close endpoints: " + joinEndpointErrors(e.Endpoints)
+}
+
+// Unwrap exposes the endpoint errors to errors.Is and errors.As.
+func (e *CloseError) Unwrap() []error {
+	return unwrapEndpointErrors(e.Endpoints)
+}
+
+// Close stops background work and closes the master and every replica.
+// New queries are rejected immediately, while in-flight queries may finish until ctx is done.
+// Connections still in use after that are closed, failing their queries, and the
+// endpoint is reported in a *CloseError along with endpoints that failed to close.
+func (c *Client) Close(ctx context.Context) error {
+	c.stopBackground()
+
+	results := make([]chan error, len(c.endpoints))
+	for i, ep := range c.endpoints {
+		results[i] = make(chan error, 1)
+		go func() {
+			var err error
+			// Closing the database/sql wrapper waits for running queries
+			// and releases its connections back to the pool.
+			if ep.db != nil {
+				err = ep.db.Close()
+			}
+			// Closing the pool waits for every acquired connection to be released.
+			ep.pool.Close()
+			results[i] <- err
+		}()
+	}
+
+	closeErr := &CloseError{}
+	for i, ep := range c.endpoints {
+		var err error
+		select {
+		case err = <-results[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+			if closed := ep.conns.closeAcquired(); closed > 0 {
+				err = errors.Wrapf(err, "closed %d connections still in use", closed)
+			}
+		}
+		if err != nil {
+			closeErr.Endpoints = append(closeErr.Endpoints, &EndpointError{Role: ep.role, Index: ep.index, Host: ep.host, Err: err})
+		}
+	}
+	if len(closeErr.Endpoints) > 0 {
+		return closeErr
+	}
+	return nil
+}
+
+// connTracker records the open connections of a pool and whether they are
+// acquired. It caps idle connections, and lets Close close the acquired ones
+// once its deadline passes, as pgxpool only closes connections after they are
+// released.
+type connTracker struct {
+	// Idle connections kept at most; zero keeps every one.
+	maxIdle int
+
+	mu sync.Mutex
+	// Open connections, mapped to whether they are acquired.
+	conns map[*pgx.Conn]bool
+}
+
+// newConnTracker tracks the connections of the pool created from cfg.
+func newConnTracker(cfg *pgxpool.Config, maxIdle int) *connTracker {
+	t := &connTracker{maxIdle: maxIdle, conns: make(map[*pgx.Conn]bool)}
+
+	afterConnect := cfg.AfterConnect
+	cfg.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+		t.set(conn, false)
+		return nil
+	}
+
+	prepareConn := cfg.PrepareConn
+	cfg.PrepareConn = func(ctx context.Context, conn *pgx.Conn) (bool, error) {
+		if prepareConn != nil {
+			if ok, err := prepareConn(ctx, conn); !ok || err != nil {
+				return ok, err
+			}
+		}
+		t.set(conn, true)
+		return true, nil
+	}
+
+	afterRelease := cfg.AfterRelease
+	cfg.AfterRelease = func(conn *pgx.Conn) bool {
+		if afterRelease != nil && !afterRelease(conn) {
+			return false
+		}
+		return t.release(conn)
+	}
+
+	beforeClose := cfg.BeforeClose
+	cfg.BeforeClose = func(conn *pgx.Conn) {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		if beforeClose != nil {
+			beforeClose(conn)
+		}
+	}
+	return t
+}
+
+func (t *connTracker) set(conn *pgx.Conn, acquired bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[conn] = acquired
+}
+
+// release marks conn idle, or reports false to close it when the pool already
+// holds maxIdle idle connections.
+func (t *connTracker) release(conn *pgx.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.maxIdle > 0 {
+		idle := 0
+		for _, acquired := range t.conns {
+			if !acquired {
+				idle++
+			}
+		}
+		if idle >= t.maxIdle {
+			return false
+		}
+	}
+	t.conns[conn] = false
+	return true
+}
+
+// closeAcquired closes the network connection of every acquired connection,
+// which fails the queries running on them, and returns how many were closed.
+// Idle connections are left to the pool.
+func (t *connTracker) closeAcquired() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	closed := 0
+	for conn, acquired := range t.conns {
+		if acquired {
+			_ = conn.PgConn().Conn().Close()
+			closed++
+		}
+	}
+	return closed
+}
@@ -0,0 +1,186 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// QueryCommentConfig enables sqlcommenter-style comments appended to every statement,
+// e.g. /*application='orders',route='%2Fv1%2Forders',traceparent='00-...'*/.
+// Comments make each statement text unique per request, which defeats prepared
+// statement caching, so they are off unless configured.
+type QueryCommentConfig struct {
+	// Traceparent returns the W3C traceparent of ctx, e.g. from the OpenTelemetry span context.
+	Traceparent func(ctx context.Context) string `json:"-" yaml:"-"`
+}
+
+type queryTagsKey struct{}
+
+// WithQueryTags returns a context whose statements are tagged with tags, such as
+// "route" or "controller", when DBConn.QueryComment is set. Tags are added to
+// those already in ctx.
+func WithQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	merged := maps.Clone(QueryTagsFrom(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(tags))
+	}
+	maps.Copy(merged, tags)
+	return context.WithValue(ctx, queryTagsKey{}, merged)
+}
+
+// QueryTagsFrom returns the query tags of ctx. The map must not be modified.
+func QueryTagsFrom(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	tags, _ := ctx.Value(queryTagsKey{}).(map[string]string)
+	return tags
+}
+
+type queryCommenter struct {
+	cfg         *QueryCommentConfig
+	application string
+}
+
+// registerQueryComment installs callbacks that route the statement through a
+// connection pool appending the comment, around the callback executing it.
+// The comment is removed before GORM's default transaction and the local
+// settings transaction end, as they need the original pool to commit.
+func registerQueryComment(db *gorm.DB, cfg *QueryCommentConfig, applicationName string) error {
+	c := &queryCommenter{cfg: cfg, application: applicationName}
+	callback := db.Callback()
+
+	if err := callback.Create().Before("gorm:create").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	if err := callback.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").Register("postgres:comment_end", c.end); err != nil {
+		return err
+	}
+	if err := callback.Query().After("postgres:local_begin").Before("gorm:query").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	if err := callback.Query().After("gorm:query").Before("postgres:local_end").Register("postgres:comment_end", c.end); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").Register("postgres:comment_end", c.end); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").Register("postgres:comment_end", c.end); err != nil {
+		return err
+	}
+	if err := callback.Row().After("postgres:local_begin").Before("gorm:row").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	if err := callback.Row().After("gorm:row").Before("postgres:local_end").Register("postgres:comment_end", c.end); err != nil {
+		return err
+	}
+	if err := callback.Raw().After("postgres:local_begin").Before("gorm:raw").Register("postgres:comment_begin", c.begin); err != nil {
+		return err
+	}
+	return callback.Raw().After("gorm:raw").Before("postgres:local_end").Register("postgres:comment_end", c.end)
+}
+
+func (c *queryCommenter) begin(db *gorm.DB) {
+	if db.Error != nil || db.Statement.ConnPool == nil {
+		return
+	}
+	comment := c.comment(db.Statement.Context)
+	if comment == "" {
+		return
+	}
+	pool := &commentConnPool{ConnPool: db.Statement.ConnPool, comment: comment}
+	if tx, ok := pool.ConnPool.(gorm.TxCommitter); ok {
+		db.Statement.ConnPool = &commentTx{commentConnPool: pool, tx: tx}
+		return
+	}
+	db.Statement.ConnPool = pool
+}
+
+// end restores the connection pool, so transaction handling sees the original one.
+func (c *queryCommenter) end(db *gorm.DB) {
+	switch pool := db.Statement.ConnPool.(type) {
+	case *commentConnPool:
+		db.Statement.ConnPool = pool.ConnPool
+	case *commentTx:
+		db.Statement.ConnPool = pool.ConnPool
+	}
+}
+
+// comment renders the tags of ctx as a sqlcommenter comment, sorted by key.
+// Keys and values are URL encoded, so they cannot contain quotes or end the comment.
+func (c *queryCommenter) comment(ctx context.Context) string {
+	tags := maps.Clone(QueryTagsFrom(ctx))
+	if tags == nil {
+		tags = make(map[string]string, 2)
+	}
+	if c.application != "" {
+		tags["application"] = c.application
+	}
+	if c.cfg.Traceparent != nil && ctx != nil {
+		if traceparent := c.cfg.Traceparent(ctx); traceparent != "" {
+			tags["traceparent"] = traceparent
+		}
+	}
+	if len(tags) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(tags))
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, escapeCommentValue(key)+"='"+escapeCommentValue(tags[key])+"'")
+	}
+	return " /*" + strings.Join(pairs, ",") + "*/"
+}
+
+func escapeCommentValue(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// commentConnPool appends a comment to every statement it runs.
+type commentConnPool struct {
+	gorm.ConnPool
+	comment string
+}
+
+func (p *commentConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.ConnPool.PrepareContext(ctx, query+p.comment)
+}
+
+func (p *commentConnPool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return p.ConnPool.ExecContext(ctx, query+p.comment, args...)
+}
+
+func (p *commentConnPool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.ConnPool.QueryContext(ctx, query+p.comment, args...)
+}
+
+func (p *commentConnPool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return p.ConnPool.QueryRowContext(ctx, query+p.comment, args...)
+}
+
+// commentTx wraps a transaction, so it can still be committed or rolled back
+// while the comment is applied.
+type commentTx struct {
+	*commentConnPool
+	tx gorm.TxCommitter
+}
+
+func (t *commentTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *commentTx) Rollback() error {
+	return t.tx.Rollback()
+}
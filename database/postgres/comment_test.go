@@ -0,0 +1,155 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type commentOrder struct {
+	ID   int64
+	Name string
+}
+
+func TestQueryCommentCreateInTransaction(t *testing.T) {
+	recorder := &statementRecorder{}
+	sqlDB := sql.OpenDB(recorder)
+	defer sqlDB.Close()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{SkipDefaultTransaction: false})
+	if err != nil {
+		t.Fatalf("failed to open gorm: %v", err)
+	}
+	if err := registerLocalScope(db, nil); err != nil {
+		t.Fatalf("failed to register local scope: %v", err)
+	}
+	if err := registerQueryComment(db, &QueryCommentConfig{}, "orders"); err != nil {
+		t.Fatalf("failed to register query comment: %v", err)
+	}
+
+	ctx := WithQueryTags(context.Background(), map[string]string{"route": "/v1/orders"})
+	if err := db.WithContext(ctx).Create(&commentOrder{Name: "first"}).Error; err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	statements := recorder.all()
+	want := []string{"BEGIN", "INSERT", "COMMIT"}
+	if len(statements) != len(want) {
+		t.Fatalf("statements = %q, want %v", statements, want)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(statements[i], prefix) {
+			t.Fatalf("statements = %q, want %v", statements, want)
+		}
+	}
+	if comment := " /*application='orders',route='%2Fv1%2Forders'*/"; !strings.HasSuffix(statements[1], comment) {
+		t.Errorf("insert = %q, want suffix %q", statements[1], comment)
+	}
+	if inUse := sqlDB.Stats().InUse; inUse != 0 {
+		t.Errorf("connections in use = %d, want 0", inUse)
+	}
+}
+
+// statementRecorder is a database/sql connector recording the statements and
+// transaction boundaries it receives. Queries return a single id column.
+type statementRecorder struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (r *statementRecorder) record(statement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, statement)
+}
+
+func (r *statementRecorder) all() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.statements...)
+}
+
+func (r *statementRecorder) Connect(context.Context) (driver.Conn, error) {
+	return &recorderConn{recorder: r}, nil
+}
+
+func (r *statementRecorder) Driver() driver.Driver {
+	return recorderDriver{recorder: r}
+}
+
+type recorderDriver struct {
+	recorder *statementRecorder
+}
+
+func (d recorderDriver) Open(string) (driver.Conn, error) {
+	return &recorderConn{recorder: d.recorder}, nil
+}
+
+type recorderConn struct {
+	recorder *statementRecorder
+}
+
+func (c *recorderConn) Prepare(string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *recorderConn) Close() error {
+	return nil
+}
+
+func (c *recorderConn) Begin() (driver.Tx, error) {
+	c.recorder.record("BEGIN")
+	return recorderTx{recorder: c.recorder}, nil
+}
+
+func (c *recorderConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.recorder.record(query)
+	return driver.RowsAffected(1), nil
+}
+
+func (c *recorderConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	c.recorder.record(query)
+	return &recorderRows{}, nil
+}
+
+type recorderTx struct {
+	recorder *statementRecorder
+}
+
+func (tx recorderTx) Commit() error {
+	tx.recorder.record("COMMIT")
+	return nil
+}
+
+func (tx recorderTx) Rollback() error {
+	tx.recorder.record("ROLLBACK")
+	return nil
+}
+
+type recorderRows struct {
+	done bool
+}
+
+func (r *recorderRows) Columns() []string {
+	return []string{"id"}
+}
+
+func (r *recorderRows) Close() error {
+	return nil
+}
+
+func (r *recorderRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(1)
+	return nil
+}
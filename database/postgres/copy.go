@@ -0,0 +1,189 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// CopyFormat is the data format of CopyTo.
+type CopyFormat string
+
+const (
+	CopyFormatCSV    CopyFormat = "csv"
+	CopyFormatBinary CopyFormat = "binary"
+)
+
+// CopyToOptions defines the output of CopyTo.
+type CopyToOptions struct {
+	// Format defaults to CSV.
+	Format CopyFormat
+	// Header writes the column names as the first CSV line.
+	Header bool
+}
+
+// CopyFrom streams rows into table ("table" or "schema.table") with COPY FROM.
+// Rows can come from pgx.CopyFromRows, pgx.CopyFromSlice or any iterator implementing
+// pgx.CopyFromSource. It returns the number of rows copied.
+func CopyFrom(ctx context.Context, db *gorm.DB, table string, columns []string, rows pgx.CopyFromSource) (int64, error) {
+	var copied int64
+	err := withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		var err error
+		copied, err = conn.CopyFrom(ctx, pgx.Identifier(strings.Split(table, ".")), columns, rows)
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to copy rows")
+	}
+	return copied, nil
+}
+
+// CopyFromStructs copies a slice of structs, or pointers to structs, with COPY FROM.
+// The table and columns come from the GORM schema of the element type; db.Table
+// overrides the table. Columns filled by the database, such as serial primary keys
+// and default:gen_random_uuid(), are left out unless the rows set them, in which
+// case every row must. Static defaults and automatic timestamps are set on zero
+// fields as Create does, but hooks are not run.
+func CopyFromStructs(ctx context.Context, db *gorm.DB, values any) (int64, error) {
+	rv := reflect.Indirect(reflect.ValueOf(values))
+	if rv.Kind() != reflect.Slice {
+		return 0, errors.Errorf("values must be a slice, got %T", values)
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(values); err != nil {
+		return 0, errors.Wrap(err, "failed to parse schema")
+	}
+	table := stmt.Schema.Table
+	if db.Statement.Table != "" {
+		table = db.Statement.Table
+	}
+
+	fields, err := copyFields(ctx, stmt.Schema, rv)
+	if err != nil {
+		return 0, err
+	}
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		columns[i] = field.DBName
+	}
+
+	now := time.Now()
+	rows := pgx.CopyFromSlice(rv.Len(), func(i int) ([]any, error) {
+		elem := reflect.Indirect(rv.Index(i))
+		row := make([]any, len(fields))
+		for j, field := range fields {
+			value, zero := field.ValueOf(ctx, elem)
+			if zero {
+				switch {
+				case field.AutoCreateTime > 0 || field.AutoUpdateTime > 0:
+					if err := field.Set(ctx, elem, now); err != nil {
+						return nil, errors.Wrapf(err, "failed to set %s", field.Name)
+					}
+					value, _ = field.ValueOf(ctx, elem)
+				case field.DefaultValueInterface != nil:
+					value = field.DefaultValueInterface
+				}
+			}
+			row[j] = value
+		}
+		return row, nil
+	})
+
+	return CopyFrom(ctx, db, table, columns, rows)
+}
+
+// copyFields returns the fields of s copied for the rows in rv. COPY takes a single
+// column list, so a column filled by the database is copied only when the rows
+// set it, and then every row must set it.
+func copyFields(ctx context.Context, s *schema.Schema, rv reflect.Value) ([]*schema.Field, error) {
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if field.DBName == "" || !field.Creatable {
+			continue
+		}
+		if field.HasDefaultValue && field.DefaultValueInterface == nil && field.AutoCreateTime == 0 && field.AutoUpdateTime == 0 {
+			set := 0
+			for i := range rv.Len() {
+				if _, zero := field.ValueOf(ctx, reflect.Indirect(rv.Index(i))); !zero {
+					set++
+				}
+			}
+			if set == 0 {
+				continue
+			}
+			if set < rv.Len() {
+				return nil, errors.Errorf("%s is set on %d of %d rows; set it on every row or none", field.Name, set, rv.Len())
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// CopyTo exports the result of query with COPY TO and writes it to w.
+// query is a SELECT statement, e.g. "SELECT * FROM users"; it cannot take arguments.
+// It returns the number of rows copied.
+func CopyTo(ctx context.Context, db *gorm.DB, w io.Writer, query string, opts CopyToOptions) (int64, error) {
+	format := opts.Format
+	if format == "" {
+		format = CopyFormatCSV
+	}
+
+	var options string
+	switch format {
+	case CopyFormatCSV:
+		options = "FORMAT csv"
+		if opts.Header {
+			options += ", HEADER"
+		}
+	case CopyFormatBinary:
+		options = "FORMAT binary"
+	default:
+		return 0, errors.Errorf("unknown copy format %q", format)
+	}
+
+	var copied int64
+	err := withPgxConn(ctx, db, func(conn *pgx.Conn) error {
+		tag, err := conn.PgConn().CopyTo(ctx, w, "COPY ("+query+") TO STDOUT WITH ("+options+")")
+		copied = tag.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to copy rows")
+	}
+	return copied, nil
+}
+
+// withPgxConn runs fn on the pgx connection behind a connection of db's pool.
+func withPgxConn(ctx context.Context, db *gorm.DB, fn func(conn *pgx.Conn) error) error {
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return errors.New("COPY is not supported inside a GORM transaction")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return errors.Wrap(err, "failed to get sql.DB")
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get connection")
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.Errorf("unsupported driver connection %T", driverConn)
+		}
+		return fn(stdlibConn.Conn())
+	})
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+type copyItem struct {
+	ID        int64
+	UUID      string `gorm:"type:uuid;default:gen_random_uuid()"`
+	Status    string `gorm:"default:new"`
+	Note      string
+	CreatedAt time.Time
+}
+
+func TestCopyFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		rows    []copyItem
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "database defaults left out",
+			rows: []copyItem{{Note: "a"}, {Note: "b"}},
+			want: []string{"status", "note", "created_at"},
+		},
+		{
+			name: "primary key set on every row",
+			rows: []copyItem{{ID: 1}, {ID: 2}},
+			want: []string{"id", "status", "note", "created_at"},
+		},
+		{
+			name: "uuid set on every row",
+			rows: []copyItem{{UUID: "6f1c7a52-3c8e-4f7a-9d43-2d1c6b0e8a11"}},
+			want: []string{"uuid", "status", "note", "created_at"},
+		},
+		{
+			name:    "primary key set on some rows",
+			rows:    []copyItem{{ID: 1}, {}},
+			wantErr: true,
+		},
+	}
+
+	s, err := schema.Parse(&copyItem{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, err := copyFields(context.Background(), s, reflect.ValueOf(tt.rows))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("copyFields() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("copyFields() error = %v", err)
+			}
+			var columns []string
+			for _, field := range fields {
+				columns = append(columns, field.DBName)
+			}
+			if !reflect.DeepEqual(columns, tt.want) {
+				t.Errorf("copyFields() columns = %v, want %v", columns, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// Credentials are the user name and password for a new connection.
+type Credentials struct {
+	// Empty keeps the configured user name.
+	UserName string
+	Password string
+}
+
+// CredentialProvider supplies credentials before each new physical connection,
+// allowing short-lived tokens such as RDS IAM auth or Vault dynamic secrets.
+// It receives the primary host of the endpoint; fallback hosts from
+// ConnectionConfig.Hosts are tried with the same credentials, so host-scoped
+// tokens need an endpoint per host instead.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string, port uint16) (Credentials, error)
+}
+
+// CredentialProviderFunc adapts a function to CredentialProvider.
+type CredentialProviderFunc func(ctx context.Context, host string, port uint16) (Credentials, error)
+
+// Credentials implements CredentialProvider.
+func (f CredentialProviderFunc) Credentials(ctx context.Context, host string, port uint16) (Credentials, error) {
+	return f(ctx, host, port)
+}
+
+// FileCredentialProvider reads the password from a file, e.g. a secret mounted
+// by Kubernetes or rendered by Vault agent. The file is checked with a stat
+// before each new connection and read again when its size or modification time
+// changed; it is not watched.
+type FileCredentialProvider struct {
+	path     string
+	userName string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	size     int64
+	password string
+}
+
+// NewFileCredentialProvider creates a provider reading the password from path.
+// An empty userName keeps the configured user name.
+func NewFileCredentialProvider(path, userName string) *FileCredentialProvider {
+	return &FileCredentialProvider{path: path, userName: userName}
+}
+
+// Credentials implements CredentialProvider.
+func (p *FileCredentialProvider) Credentials(context.Context, string, uint16) (Credentials, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return Credentials{}, errors.Wrap(err, "failed to stat credential file")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !info.ModTime().Equal(p.modTime) || info.Size() != p.size {
+		data, err := os.ReadFile(p.path)
+		if err != nil {
+			return Credentials{}, errors.Wrap(err, "failed to read credential file")
+		}
+		p.password = strings.TrimRight(string(data), "\r\n")
+		p.modTime = info.ModTime()
+		p.size = info.Size()
+	}
+
+	return Credentials{UserName: p.userName, Password: p.password}, nil
+}
+
+// beforeConnect returns a pgx hook that applies credentials from provider.
+func beforeConnect(provider CredentialProvider) func(context.Context, *pgx.ConnConfig) error {
+	return func(ctx context.Context, cfg *pgx.ConnConfig) error {
+		return applyCredentials(ctx, cfg, provider)
+	}
+}
+
+func applyCredentials(ctx context.Context, cfg *pgx.ConnConfig, provider CredentialProvider) error {
+	creds, err := provider.Credentials(ctx, cfg.Host, cfg.Port)
+	if err != nil {
+		return errors.Wrap(err, "failed to get credentials")
+	}
+
+	if creds.UserName != "" {
+		cfg.User = creds.UserName
+	}
+	cfg.Password = creds.Password
+	return nil
+}
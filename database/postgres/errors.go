@@ -0,0 +1,201 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// Sentinel errors for common PostgreSQL error classes.
+// Use the Is* predicates or ClassifyError to match them against errors returned by GORM or pgx.
+var (
+	ErrUniqueViolation      = errors.New("unique violation")
+	ErrForeignKeyViolation  = errors.New("foreign key violation")
+	ErrNotNullViolation     = errors.New("not null violation")
+	ErrCheckViolation       = errors.New("check violation")
+	ErrSerializationFailure = errors.New("serialization failure")
+	ErrDeadlock             = errors.New("deadlock detected")
+	ErrLockTimeout          = errors.New("lock timeout")
+	ErrStatementTimeout     = errors.New("statement timeout")
+	ErrQueryCanceled        = errors.New("query canceled")
+	ErrReadOnlyTransaction  = errors.New("read-only transaction")
+	ErrConnectionFailure    = errors.New("connection failure")
+)
+
+// SQLSTATE codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html.
+var _errorCodes = map[string]error{
+	"23505": ErrUniqueViolation,
+	"23503": ErrForeignKeyViolation,
+	"23502": ErrNotNullViolation,
+	"23514": ErrCheckViolation,
+	"40001": ErrSerializationFailure,
+	"40P01": ErrDeadlock,
+	"55P03": ErrLockTimeout,
+	"25006": ErrReadOnlyTransaction,
+	// Server shutting down or not accepting connections yet.
+	"57P01": ErrConnectionFailure,
+	"57P02": ErrConnectionFailure,
+	"57P03": ErrConnectionFailure,
+}
+
+// Errors produced by GORM's TranslateError option, which drop the *pgconn.PgError.
+var _gormErrors = map[error]error{
+	gorm.ErrDuplicatedKey:           ErrUniqueViolation,
+	gorm.ErrForeignKeyViolated:      ErrForeignKeyViolation,
+	gorm.ErrCheckConstraintViolated: ErrCheckViolation,
+}
+
+// Error is a classified PostgreSQL error.
+// errors.Is matches its Kind, and errors.As still reaches the underlying *pgconn.PgError.
+type Error struct {
+	// Kind is one of the sentinel errors, e.g. ErrUniqueViolation.
+	Kind error
+	// SQLSTATE code; empty when the error did not come from the server.
+	Code       string
+	Table      string
+	Column     string
+	Constraint string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the error class.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// ClassifyError returns err as an *Error when it belongs to one of the known classes,
+// or nil otherwise. err may be wrapped, e.g. by GORM.
+func ClassifyError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var classified *Error
+	if errors.As(err, &classified) {
+		return classified
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		kind := pgErrorKind(pgErr)
+		if kind == nil {
+			return nil
+		}
+		return &Error{
+			Kind:       kind,
+			Code:       pgErr.Code,
+			Table:      pgErr.TableName,
+			Column:     pgErr.ColumnName,
+			Constraint: pgErr.ConstraintName,
+			Err:        err,
+		}
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return &Error{Kind: ErrConnectionFailure, Err: err}
+	}
+
+	for gormErr, kind := range _gormErrors {
+		if errors.Is(err, gormErr) {
+			return &Error{Kind: kind, Err: err}
+		}
+	}
+	return nil
+}
+
+func pgErrorKind(pgErr *pgconn.PgError) error {
+	if kind, ok := _errorCodes[pgErr.Code]; ok {
+		return kind
+	}
+
+	switch {
+	case strings.HasPrefix(pgErr.Code, "08"):
+		return ErrConnectionFailure
+	case pgErr.Code == "57014":
+		// query_canceled is shared by statement timeouts and cancel requests, which
+		// only the message tells apart. Localized messages (lc_messages) fall back
+		// to ErrQueryCanceled.
+		if strings.Contains(pgErr.Message, "statement timeout") {
+			return ErrStatementTimeout
+		}
+		return ErrQueryCanceled
+	}
+	return nil
+}
+
+func isErrorKind(err, kind error) bool {
+	classified := ClassifyError(err)
+	return classified != nil && classified.Kind == kind
+}
+
+// IsUniqueViolation reports whether err is a unique constraint violation (23505).
+func IsUniqueViolation(err error) bool {
+	return isErrorKind(err, ErrUniqueViolation)
+}
+
+// IsForeignKeyViolation reports whether err is a foreign key violation (23503).
+func IsForeignKeyViolation(err error) bool {
+	return isErrorKind(err, ErrForeignKeyViolation)
+}
+
+// IsNotNullViolation reports whether err is a not null violation (23502).
+func IsNotNullViolation(err error) bool {
+	return isErrorKind(err, ErrNotNullViolation)
+}
+
+// IsCheckViolation reports whether err is a check constraint violation (23514).
+func IsCheckViolation(err error) bool {
+	return isErrorKind(err, ErrCheckViolation)
+}
+
+// IsSerializationFailure reports whether err is a serialization failure (40001).
+func IsSerializationFailure(err error) bool {
+	return isErrorKind(err, ErrSerializationFailure)
+}
+
+// IsDeadlock reports whether err is a detected deadlock (40P01).
+func IsDeadlock(err error) bool {
+	return isErrorKind(err, ErrDeadlock)
+}
+
+// IsLockTimeout reports whether a lock could not be acquired in time or with NOWAIT (55P03).
+func IsLockTimeout(err error) bool {
+	return isErrorKind(err, ErrLockTimeout)
+}
+
+// IsStatementTimeout reports whether err was caused by statement_timeout (57014).
+// The server reports it with the same code as a cancel request, so it is only
+// recognized from English messages; with a localized lc_messages it is
+// reported as a canceled query instead.
+func IsStatementTimeout(err error) bool {
+	return isErrorKind(err, ErrStatementTimeout)
+}
+
+// IsQueryCanceled reports whether the query was canceled by a cancel request (57014),
+// or by statement_timeout when the server's messages are not in English.
+func IsQueryCanceled(err error) bool {
+	return isErrorKind(err, ErrQueryCanceled)
+}
+
+// IsReadOnlyTransaction reports whether a write ran in a read-only transaction (25006),
+// e.g. on a standby.
+func IsReadOnlyTransaction(err error) bool {
+	return isErrorKind(err, ErrReadOnlyTransaction)
+}
+
+// IsConnectionFailure reports whether err is a connection exception (class 08),
+// a failed connect or a server shutting down.
+func IsConnectionFailure(err error) bool {
+	return isErrorKind(err, ErrConnectionFailure)
+}
@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const _defaultPingTimeout = 5 * time.Second
+
+// Role identifies whether an endpoint is the master or a replica.
+type Role string
+
+const (
+	RoleMaster  Role = "master"
+	RoleReplica Role = "replica"
+)
+
+// HealthEvent describes a health state change of a single endpoint.
+type HealthEvent struct {
+	Role Role
+	// Index of the replica in DBConn.EffectiveReplicas; -1 for the master.
+	Index   int
+	Host    string
+	Healthy bool
+	// Lagging is set when a replica exceeds MaxReplicationLag.
+	Lagging bool
+	// Lag is the last measured replication lag of a replica.
+	Lag time.Duration
+	// Err is the cause when the endpoint became unhealthy or lagging.
+	Err error
+}
+
+// healthChecker pings the master and every replica on a fixed period,
+// toggles replica eligibility for routing and records replica ping times.
+type healthChecker struct {
+	period        time.Duration
+	master        *pgxpool.Pool
+	masterHost    string
+	masterHealthy bool
+	router        *router
+	notify        func(HealthEvent)
+}
+
+func (h *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(h.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx)
+		}
+	}
+}
+
+// check pings the master and every replica concurrently, so endpoints that are
+// down delay it by a single ping timeout.
+func (h *healthChecker) check(ctx context.Context) {
+	var replicas []*replica
+	if h.router != nil {
+		replicas = h.router.replicas
+	}
+
+	var masterErr error
+	errs := make([]error, len(replicas))
+	latencies := make([]time.Duration, len(replicas))
+	var wg sync.WaitGroup
+	wg.Add(1 + len(replicas))
+	go func() {
+		defer wg.Done()
+		masterErr = h.ping(ctx, h.master)
+	}()
+	for i, rep := range replicas {
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			errs[i] = h.ping(ctx, rep.pool)
+			latencies[i] = time.Since(start)
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	if healthy := masterErr == nil; healthy != h.masterHealthy {
+		h.masterHealthy = healthy
+		h.emit(HealthEvent{Role: RoleMaster, Index: -1, Host: h.masterHost, Healthy: healthy, Err: masterErr})
+	}
+	for i, rep := range replicas {
+		err := errs[i]
+		if err == nil {
+			rep.latency.Store(int64(latencies[i]))
+		}
+		if healthy := err == nil; rep.healthy.Swap(healthy) != healthy {
+			h.emit(HealthEvent{Role: RoleReplica, Index: rep.index, Host: rep.host, Healthy: healthy, Err: err})
+		}
+	}
+}
+
+func (h *healthChecker) ping(ctx context.Context, pool *pgxpool.Pool) error {
+	ctx, cancel := context.WithTimeout(ctx, min(h.period, _defaultPingTimeout))
+	defer cancel()
+	return pool.Ping(ctx)
+}
+
+func (h *healthChecker) emit(event HealthEvent) {
+	if h.notify != nil {
+		h.notify(event)
+	}
+}
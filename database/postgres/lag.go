@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const _defaultLagCheckPeriod = 5 * time.Second
+
+// _maxWALSamples bounds the master WAL positions kept for measuring lag, about
+// 85 minutes at the default period. Older lag is reported as that age.
+const _maxWALSamples = 1024
+
+// _replicationLagQuery returns the time since a standby replayed its last
+// transaction in seconds, with its replay and receive LSNs.
+const _replicationLagQuery = `SELECT COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)::float8,
+	COALESCE(pg_last_wal_replay_lsn() - '0/0', 0)::int8,
+	COALESCE(pg_last_wal_receive_lsn() - '0/0', 0)::int8`
+
+// ErrReplicationLagExceeded is reported when a replica falls behind MaxReplicationLag.
+var ErrReplicationLagExceeded = errors.New("replication lag exceeded")
+
+// lagChecker measures the replay lag and position of every replica and
+// removes replicas over the limit from read routing when maxLag is set.
+type lagChecker struct {
+	period time.Duration
+	maxLag time.Duration
+	router *router
+	notify func(HealthEvent)
+
+	// Master WAL positions by the time they were read, oldest first.
+	samples []walSample
+}
+
+// walSample is the WAL position of the master at the time it was read.
+type walSample struct {
+	lsn int64
+	at  time.Time
+}
+
+func (l *lagChecker) run(ctx context.Context) {
+	l.check(ctx)
+
+	ticker := time.NewTicker(l.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.check(ctx)
+		}
+	}
+}
+
+func (l *lagChecker) check(ctx context.Context) {
+	l.sample(ctx)
+	minReplayLSN := int64(-1)
+	for _, rep := range l.router.replicas {
+		lag, err := l.measure(ctx, rep)
+		if ctx.Err() != nil {
+			return
+		}
+		if replayLSN := rep.replayLSN.Load(); err == nil && (minReplayLSN == -1 || replayLSN < minReplayLSN) {
+			minReplayLSN = replayLSN
+		}
+		if l.maxLag == 0 {
+			continue
+		}
+		if err == nil && lag > l.maxLag {
+			err = errors.Wrapf(ErrReplicationLagExceeded, "%s behind", lag)
+		}
+
+		if lagging := err != nil; rep.lagging.Swap(lagging) != lagging {
+			l.emit(HealthEvent{
+				Role:    RoleReplica,
+				Index:   rep.index,
+				Host:    rep.host,
+				Healthy: rep.healthy.Load(),
+				Lagging: lagging,
+				Lag:     lag,
+				Err:     err,
+			})
+		}
+	}
+	l.prune(minReplayLSN)
+}
+
+// sample records the current WAL position of the master when it moved since
+// the previous check. Failures leave the samples unchanged.
+func (l *lagChecker) sample(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, min(l.period, _defaultPingTimeout))
+	defer cancel()
+
+	var lsn int64
+	if err := l.router.masterPool.QueryRow(ctx, _currentWALLSNQuery).Scan(&lsn); err != nil {
+		return
+	}
+	if n := len(l.samples); n > 0 && lsn <= l.samples[n-1].lsn {
+		return
+	}
+	if len(l.samples) == _maxWALSamples {
+		l.samples = l.samples[1:]
+	}
+	l.samples = append(l.samples, walSample{lsn: lsn, at: time.Now()})
+}
+
+// prune drops the samples every replica has replayed, keeping the latest.
+func (l *lagChecker) prune(replayLSN int64) {
+	for len(l.samples) > 1 && l.samples[0].lsn <= replayLSN {
+		l.samples = l.samples[1:]
+	}
+}
+
+// lagBehind returns how long ago the master was first seen past replayLSN and
+// whether the master position is known at all. WAL written since the previous check, such as a
+// checkpoint on an idle master, therefore only counts as lag of a few
+// milliseconds until the next check.
+func (l *lagChecker) lagBehind(replayLSN int64) (time.Duration, bool) {
+	for _, sample := range l.samples {
+		if sample.lsn > replayLSN {
+			return time.Since(sample.at), true
+		}
+	}
+	return 0, len(l.samples) > 0
+}
+
+// measure returns the replay lag of a replica: the time since the master wrote
+// the oldest WAL the replica has not replayed, to the precision of the check
+// period. Until the master position is known, a replica that replayed
+// everything it received is taken as caught up, and otherwise lags by the time
+// since it last replayed a transaction.
+func (l *lagChecker) measure(ctx context.Context, rep *replica) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, min(l.period, _defaultPingTimeout))
+	defer cancel()
+
+	var (
+		seconds    float64
+		replayLSN  int64
+		receiveLSN int64
+	)
+	if err := rep.pool.QueryRow(ctx, _replicationLagQuery).Scan(&seconds, &replayLSN, &receiveLSN); err != nil {
+		return 0, errors.Wrap(err, "failed to query replication lag")
+	}
+
+	lag, known := l.lagBehind(replayLSN)
+	if !known && replayLSN != receiveLSN {
+		lag = time.Duration(seconds * float64(time.Second))
+	}
+	rep.lag.Store(int64(lag))
+	rep.replayLSN.Store(replayLSN)
+	return lag, nil
+}
+
+func (l *lagChecker) emit(event HealthEvent) {
+	if l.notify != nil {
+		l.notify(event)
+	}
+}
@@ -0,0 +1,224 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	_defaultListenerPingPeriod     = 30 * time.Second
+	_defaultListenerInitialBackoff = 500 * time.Millisecond
+	_defaultListenerMaxBackoff     = 30 * time.Second
+	_defaultListenerBufferSize     = 64
+)
+
+// Notification is a message received through LISTEN.
+type Notification struct {
+	Channel string
+	Payload string
+	// Backend process ID of the notifying session.
+	PID uint32
+}
+
+// ListenerGap describes a period without a connection, during which
+// notifications were lost. Consumers such as caches should resynchronize.
+type ListenerGap struct {
+	Start time.Time
+	End   time.Time
+	// Error that dropped the connection.
+	Err error
+}
+
+// ListenerConfig defines what a Listener subscribes to and how it delivers notifications.
+type ListenerConfig struct {
+	Channels []string
+	// Handler is called for every notification, in order. When nil, notifications
+	// are delivered on Listener.Notifications instead.
+	Handler func(Notification)
+	// OnGap is called once the connection is restored after a loss.
+	OnGap func(ListenerGap)
+	// Period after which an idle connection is pinged to detect loss.
+	PingPeriod time.Duration
+	// Backoff before the second reconnect attempt; doubled after every failure up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Capacity of the Notifications channel.
+	BufferSize int
+}
+
+// Listener receives notifications on a dedicated connection to the master,
+// reconnecting and listening again whenever the connection is lost.
+type Listener struct {
+	cfg        ListenerConfig
+	connConfig *pgx.ConnConfig
+	credential CredentialProvider
+
+	notifications chan Notification
+
+	stop context.CancelFunc
+	done chan struct{}
+	once sync.Once
+}
+
+// NewListener connects to the master of conn and listens on cfg.Channels.
+// The connection is not shared with the GORM or pgx pools.
+func NewListener(ctx context.Context, conn *DBConn, cfg ListenerConfig) (*Listener, error) {
+	if len(cfg.Channels) == 0 {
+		return nil, errors.New("at least one channel is required")
+	}
+
+	preset, err := resolvePreset(conn.Preset)
+	if err != nil {
+		return nil, err
+	}
+	if preset.TransactionPooling {
+		return nil, errors.Errorf("LISTEN is not supported with preset %q", conn.Preset)
+	}
+
+	poolConfig, _, err := newPoolConfig(conn.Master.withTargetSessionAttrs("read-write"), conn, preset, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse listener config")
+	}
+
+	l := &Listener{
+		cfg:           cfg,
+		connConfig:    poolConfig.ConnConfig,
+		credential:    conn.CredentialProvider,
+		notifications: make(chan Notification, firstPositive(cfg.BufferSize, _defaultListenerBufferSize)),
+		done:          make(chan struct{}),
+	}
+
+	pgConn, err := l.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+	l.stop = stop
+	go l.run(runCtx, pgConn)
+
+	return l, nil
+}
+
+// Notifications returns the channel notifications are delivered on when no
+// Handler is configured. It is closed when the listener is closed.
+func (l *Listener) Notifications() <-chan Notification {
+	return l.notifications
+}
+
+// Close stops listening and closes the connection.
+func (l *Listener) Close() error {
+	l.once.Do(l.stop)
+	<-l.done
+	return nil
+}
+
+// connect opens a new connection and listens on every channel.
+func (l *Listener) connect(ctx context.Context) (*pgx.Conn, error) {
+	connConfig := l.connConfig.Copy()
+	if l.credential != nil {
+		if err := applyCredentials(ctx, connConfig, l.credential); err != nil {
+			return nil, err
+		}
+	}
+
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect listener")
+	}
+
+	for _, channel := range l.cfg.Channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			_ = conn.Close(context.Background())
+			return nil, errors.Wrapf(err, "failed to listen on channel %q", channel)
+		}
+	}
+	return conn, nil
+}
+
+func (l *Listener) run(ctx context.Context, conn *pgx.Conn) {
+	defer close(l.done)
+	defer close(l.notifications)
+
+	pingPeriod := firstPositive(l.cfg.PingPeriod, _defaultListenerPingPeriod)
+	for {
+		err := l.receive(ctx, conn, pingPeriod)
+		_ = conn.Close(context.Background())
+		if ctx.Err() != nil {
+			return
+		}
+
+		gap := ListenerGap{Start: time.Now(), Err: err}
+		if conn = l.reconnect(ctx); conn == nil {
+			return
+		}
+		gap.End = time.Now()
+		if l.cfg.OnGap != nil {
+			l.cfg.OnGap(gap)
+		}
+	}
+}
+
+// receive delivers notifications until the connection fails or ctx is done.
+func (l *Listener) receive(ctx context.Context, conn *pgx.Conn, pingPeriod time.Duration) error {
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, pingPeriod)
+		n, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		switch {
+		case err == nil:
+			if !l.deliver(ctx, Notification{Channel: n.Channel, Payload: n.Payload, PID: n.PID}) {
+				return ctx.Err()
+			}
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case errors.Is(err, context.DeadlineExceeded) && !conn.IsClosed():
+			pingCtx, cancel := context.WithTimeout(ctx, _defaultPingTimeout)
+			err = conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// deliver hands n to the handler or channel. It reports false when ctx is done first.
+func (l *Listener) deliver(ctx context.Context, n Notification) bool {
+	if l.cfg.Handler != nil {
+		l.cfg.Handler(n)
+		return true
+	}
+
+	select {
+	case l.notifications <- n:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reconnect retries connect with exponential backoff until it succeeds or ctx is done.
+func (l *Listener) reconnect(ctx context.Context) *pgx.Conn {
+	backoff := firstPositive(l.cfg.InitialBackoff, _defaultListenerInitialBackoff)
+	maxBackoff := firstPositive(l.cfg.MaxBackoff, _defaultListenerMaxBackoff)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+
+		if conn, err := l.connect(ctx); err == nil {
+			return conn
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
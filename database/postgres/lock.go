@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+// ErrLockNotAcquired is returned when an advisory lock is held elsewhere and
+// LockOptions asked not to wait, or the wait timed out.
+var ErrLockNotAcquired = errors.New("advisory lock not acquired")
+
+// LockOptions defines how an advisory lock is acquired.
+type LockOptions struct {
+	// NoWait returns ErrLockNotAcquired at once when the lock is held elsewhere.
+	NoWait bool
+	// Timeout bounds the wait; zero waits until the context is done.
+	Timeout time.Duration
+}
+
+// AdvisoryLockKey hashes name into a key usable with the advisory lock functions.
+// The hash is stable across processes and releases.
+func AdvisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// AdvisoryLock is a session-scoped advisory lock held on a dedicated connection.
+type AdvisoryLock struct {
+	key  int64
+	conn *sql.Conn
+	once sync.Once
+}
+
+// LockSession acquires a session-scoped advisory lock on key.
+// The lock keeps one connection of db out of the pool until Unlock is called.
+// It is refused when db runs behind a transaction pooler preset, where the
+// lock would not stay on the same server connection.
+func LockSession(ctx context.Context, db *gorm.DB, key int64, opts LockOptions) (*AdvisoryLock, error) {
+	if plugin := clientPluginOf(db); plugin != nil && plugin.preset.TransactionPooling {
+		return nil, errors.Errorf("session advisory locks are not supported with preset %q", plugin.conn.Preset)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sql.DB")
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get connection")
+	}
+
+	if err := lockSession(ctx, conn, key, opts); err != nil {
+		discardConn(conn)
+		return nil, err
+	}
+	return &AdvisoryLock{key: key, conn: conn}, nil
+}
+
+func lockSession(ctx context.Context, conn *sql.Conn, key int64, opts LockOptions) error {
+	if opts.NoWait {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+			return errors.Wrap(err, "failed to acquire advisory lock")
+		}
+		if !acquired {
+			return ErrLockNotAcquired
+		}
+		return nil
+	}
+
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('lock_timeout', $1, false)", lockTimeoutSetting(opts.Timeout)); err != nil {
+		return errors.Wrap(err, "failed to set lock timeout")
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+		if IsLockTimeout(err) {
+			return ErrLockNotAcquired
+		}
+		return errors.Wrap(err, "failed to acquire advisory lock")
+	}
+	if _, err := conn.ExecContext(ctx, "RESET lock_timeout"); err != nil {
+		return errors.Wrap(err, "failed to reset lock timeout")
+	}
+	return nil
+}
+
+// Key returns the locked key.
+func (l *AdvisoryLock) Key() int64 {
+	return l.key
+}
+
+// Unlock releases the lock and returns the connection to the pool.
+// If the lock cannot be released cleanly the connection is closed instead,
+// which releases the lock on the server.
+func (l *AdvisoryLock) Unlock(ctx context.Context) error {
+	err := errors.New("advisory lock already released")
+	l.once.Do(func() {
+		var released bool
+		err = l.conn.QueryRowContext(ctx, "SELECT pg_advisory_unlock($1)", l.key).Scan(&released)
+		if err != nil {
+			discardConn(l.conn)
+			err = errors.Wrap(err, "failed to release advisory lock")
+			return
+		}
+		err = l.conn.Close()
+		if !released {
+			err = errors.Errorf("advisory lock %d was not held", l.key)
+		}
+	})
+	return err
+}
+
+// discardConn closes the physical connection behind conn, so no session state
+// left on it returns to the pool.
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(driverConn any) error {
+		if c, ok := driverConn.(*stdlib.Conn); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), _defaultPingTimeout)
+			defer cancel()
+			_ = c.Conn().Close(ctx)
+		}
+		return nil
+	})
+	_ = conn.Close()
+}
+
+// WithXactLock runs fn in a transaction holding a transaction-scoped advisory
+// lock on key. The lock is released when the transaction ends, so it also works
+// behind transaction poolers.
+func WithXactLock(ctx context.Context, db *gorm.DB, key int64, opts LockOptions, fn func(tx *gorm.DB) error) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if opts.NoWait {
+			var acquired bool
+			if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", key).Scan(&acquired).Error; err != nil {
+				return errors.Wrap(err, "failed to acquire advisory lock")
+			}
+			if !acquired {
+				return ErrLockNotAcquired
+			}
+			return fn(tx)
+		}
+
+		// Restore the previous lock_timeout, so it does not apply to fn.
+		var lockTimeout string
+		if err := tx.Raw("SELECT current_setting('lock_timeout')").Scan(&lockTimeout).Error; err != nil {
+			return errors.Wrap(err, "failed to get lock timeout")
+		}
+		if err := tx.Exec("SELECT set_config('lock_timeout', ?, true)", lockTimeoutSetting(opts.Timeout)).Error; err != nil {
+			return errors.Wrap(err, "failed to set lock timeout")
+		}
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", key).Error; err != nil {
+			if IsLockTimeout(err) {
+				return ErrLockNotAcquired
+			}
+			return errors.Wrap(err, "failed to acquire advisory lock")
+		}
+		if err := tx.Exec("SELECT set_config('lock_timeout', ?, true)", lockTimeout).Error; err != nil {
+			return errors.Wrap(err, "failed to reset lock timeout")
+		}
+		return fn(tx)
+	})
+}
+
+// lockTimeoutSetting renders timeout as a lock_timeout value; 0 disables the timeout.
+func lockTimeoutSetting(timeout time.Duration) string {
+	if timeout <= 0 {
+		return "0"
+	}
+	return strconv.FormatInt(max(timeout.Milliseconds(), 1), 10)
+}
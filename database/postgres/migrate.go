@@ -0,0 +1,330 @@
+package postgres
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	_defaultMigrationTable = "schema_migrations"
+	// A migration file starting with this line runs outside a transaction,
+	// e.g. for CREATE INDEX CONCURRENTLY.
+	_noTransactionDirective = "-- migrate:no-transaction"
+)
+
+var _migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrChecksumMismatch is returned when an applied migration file was changed.
+var ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+// MigratorConfig defines where migrations are read from and recorded.
+type MigratorConfig struct {
+	// Directory of the migration files within the file system; defaults to the root.
+	Dir string
+	// Table recording applied versions, optionally schema qualified; defaults to schema_migrations.
+	Table string
+	// Advisory lock key held while migrating; defaults to a hash of Table.
+	LockKey int64
+	// DryRun plans the steps without applying them or creating the table.
+	DryRun bool
+}
+
+// Migration is a versioned migration read from "<version>_<name>.up.sql" and
+// the optional "<version>_<name>.down.sql".
+type Migration struct {
+	Version int64
+	Name    string
+
+	up, down                           string
+	upNoTransaction, downNoTransaction bool
+	checksum                           string
+}
+
+// MigrationStep is a migration applied, or planned in dry-run mode.
+type MigrationStep struct {
+	Version int64
+	Name    string
+	Down    bool
+}
+
+// Migrator applies SQL migrations to the master.
+type Migrator struct {
+	db         *gorm.DB
+	cfg        MigratorConfig
+	table      pgx.Identifier
+	migrations []*Migration
+}
+
+// NewMigrator reads the migrations from fsys, e.g. an embed.FS.
+func NewMigrator(db *gorm.DB, fsys fs.FS, cfg MigratorConfig) (*Migrator, error) {
+	if cfg.Dir == "" {
+		cfg.Dir = "."
+	}
+	if cfg.Table == "" {
+		cfg.Table = _defaultMigrationTable
+	}
+	if cfg.LockKey == 0 {
+		cfg.LockKey = AdvisoryLockKey(cfg.Table)
+	}
+
+	migrations, err := readMigrations(fsys, cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		cfg:        cfg,
+		table:      pgx.Identifier(strings.Split(cfg.Table, ".")),
+		migrations: migrations,
+	}, nil
+}
+
+func readMigrations(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read migration directory")
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := _migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid migration version in %s", entry.Name())
+		}
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read migration %s", entry.Name())
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, errors.Errorf("duplicate migration version %d", version)
+		}
+
+		content := string(data)
+		if match[3] == "up" {
+			if m.up != "" {
+				return nil, errors.Errorf("duplicate migration version %d", version)
+			}
+			sum := sha256.Sum256(data)
+			m.up, m.upNoTransaction, m.checksum = content, hasNoTransactionDirective(content), hex.EncodeToString(sum[:])
+		} else {
+			m.down, m.downNoTransaction = content, hasNoTransactionDirective(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, errors.Errorf("migration %d has no up file", m.Version)
+		}
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+func hasNoTransactionDirective(content string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		return line == _noTransactionDirective
+	}
+	return false
+}
+
+// Migrations returns the migrations read, ordered by version.
+func (m *Migrator) Migrations() []*Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration.
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStep, error) {
+	if len(m.migrations) == 0 {
+		return nil, nil
+	}
+	return m.MigrateTo(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// MigrateTo applies pending migrations up to and including target, and rolls back
+// applied migrations above target. A target of 0 rolls back every migration.
+// It holds an advisory lock, so concurrent runners wait for each other, and fails
+// with ErrChecksumMismatch when an applied up file was changed.
+func (m *Migrator) MigrateTo(ctx context.Context, target int64) ([]MigrationStep, error) {
+	sqlDB, err := m.db.DB()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sql.DB")
+	}
+
+	if !m.cfg.DryRun {
+		lock, err := LockSession(ctx, m.db, m.cfg.LockKey, LockOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to lock migrations")
+		}
+		defer func() { _ = lock.Unlock(context.Background()) }()
+
+		if err := m.createTable(ctx, sqlDB); err != nil {
+			return nil, err
+		}
+	}
+
+	applied, err := m.applied(ctx, sqlDB)
+	if err != nil {
+		return nil, err
+	}
+	steps, err := m.plan(applied, target)
+	if err != nil || m.cfg.DryRun {
+		return steps, err
+	}
+
+	for i, step := range steps {
+		if err := m.apply(ctx, sqlDB, step); err != nil {
+			return steps[:i], errors.Wrapf(err, "failed to apply migration %d %s", step.Version, step.Name)
+		}
+	}
+	return steps, nil
+}
+
+func (m *Migrator) createTable(ctx context.Context, sqlDB *sql.DB) error {
+	_, err := sqlDB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.table.Sanitize()+` (
+	version bigint PRIMARY KEY,
+	name text NOT NULL,
+	checksum text NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now()
+)`)
+	return errors.Wrap(err, "failed to create migration table")
+}
+
+// applied returns the checksum of every applied version.
+// A missing table, possible in dry-run mode, means nothing was applied.
+func (m *Migrator) applied(ctx context.Context, sqlDB *sql.DB) (map[int64]string, error) {
+	var exists bool
+	if err := sqlDB.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", m.table.Sanitize()).Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, "failed to check migration table")
+	}
+	applied := make(map[int64]string)
+	if !exists {
+		return applied, nil
+	}
+
+	rows, err := sqlDB.QueryContext(ctx, "SELECT version, checksum FROM "+m.table.Sanitize())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read applied migrations")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version  int64
+			checksum string
+		)
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, errors.Wrap(err, "failed to read applied migrations")
+		}
+		applied[version] = checksum
+	}
+	return applied, errors.Wrap(rows.Err(), "failed to read applied migrations")
+}
+
+// plan lists the steps that bring the applied versions to target.
+func (m *Migrator) plan(applied map[int64]string, target int64) ([]MigrationStep, error) {
+	known := make(map[int64]*Migration, len(m.migrations))
+	var steps []MigrationStep
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+		checksum, ok := applied[migration.Version]
+		if ok && checksum != migration.checksum {
+			return nil, errors.Wrapf(ErrChecksumMismatch, "migration %d %s", migration.Version, migration.Name)
+		}
+		if !ok && migration.Version <= target {
+			steps = append(steps, MigrationStep{Version: migration.Version, Name: migration.Name})
+		}
+	}
+
+	var down []int64
+	for version := range applied {
+		if version > target {
+			down = append(down, version)
+		}
+	}
+	slices.Sort(down)
+	for _, version := range slices.Backward(down) {
+		migration, ok := known[version]
+		if !ok {
+			return nil, errors.Errorf("applied migration %d has no files", version)
+		}
+		if migration.down == "" {
+			return nil, errors.Errorf("migration %d %s has no down file", version, migration.Name)
+		}
+		steps = append(steps, MigrationStep{Version: version, Name: migration.Name, Down: true})
+	}
+	return steps, nil
+}
+
+// apply runs a single step and records it, in one transaction unless the
+// file opts out with the no-transaction directive.
+func (m *Migrator) apply(ctx context.Context, sqlDB *sql.DB, step MigrationStep) error {
+	migration := m.migrations[slices.IndexFunc(m.migrations, func(mg *Migration) bool {
+		return mg.Version == step.Version
+	})]
+
+	script, noTransaction := migration.up, migration.upNoTransaction
+	record := "INSERT INTO " + m.table.Sanitize() + " (version, name, checksum) VALUES ($1, $2, $3)"
+	args := []any{migration.Version, migration.Name, migration.checksum}
+	if step.Down {
+		script, noTransaction = migration.down, migration.downNoTransaction
+		record = "DELETE FROM " + m.table.Sanitize() + " WHERE version = $1"
+		args = args[:1]
+	}
+
+	if noTransaction {
+		if _, err := sqlDB.ExecContext(ctx, script); err != nil {
+			return err
+		}
+		_, err := sqlDB.ExecContext(ctx, record, args...)
+		return err
+	}
+
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
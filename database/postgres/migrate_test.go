@@ -0,0 +1,215 @@
+package postgres
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+)
+
+func migrationFS(files map[string]string) fstest.MapFS {
+	fsys := make(fstest.MapFS, len(files))
+	for name, content := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return fsys
+}
+
+func migrationChecksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestReadMigrations(t *testing.T) {
+	fsys := migrationFS(map[string]string{
+		"db/2_add_index.up.sql":      "-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY users_email ON users (email);",
+		"db/2_add_index.down.sql":    "DROP INDEX users_email;",
+		"db/10_add_orders.up.sql":    "CREATE TABLE orders ();",
+		"db/1_create_users.up.sql":   "CREATE TABLE users ();",
+		"db/1_create_users.down.sql": "\n-- migrate:no-transaction\nDROP TABLE users;",
+		"db/README.md":               "not a migration",
+	})
+
+	migrations, err := readMigrations(fsys, "db")
+	if err != nil {
+		t.Fatalf("readMigrations() error = %v", err)
+	}
+
+	want := []*Migration{
+		{
+			Version: 1, Name: "create_users",
+			up: "CREATE TABLE users ();", down: "\n-- migrate:no-transaction\nDROP TABLE users;",
+			downNoTransaction: true,
+			checksum:          migrationChecksum("CREATE TABLE users ();"),
+		},
+		{
+			Version: 2, Name: "add_index",
+			up:              "-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY users_email ON users (email);",
+			down:            "DROP INDEX users_email;",
+			upNoTransaction: true,
+			checksum:        migrationChecksum("-- migrate:no-transaction\nCREATE INDEX CONCURRENTLY users_email ON users (email);"),
+		},
+		{
+			Version: 10, Name: "add_orders",
+			up:       "CREATE TABLE orders ();",
+			checksum: migrationChecksum("CREATE TABLE orders ();"),
+		},
+	}
+	if !reflect.DeepEqual(migrations, want) {
+		t.Errorf("readMigrations() = %+v, want %+v", migrations, want)
+	}
+}
+
+func TestReadMigrationsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{
+			name: "duplicate version with different names",
+			files: map[string]string{
+				"1_create_users.up.sql":  "CREATE TABLE users ();",
+				"1_create_orders.up.sql": "CREATE TABLE orders ();",
+			},
+		},
+		{
+			name: "duplicate up file",
+			files: map[string]string{
+				"1_create_users.up.sql":  "CREATE TABLE users ();",
+				"01_create_users.up.sql": "CREATE TABLE users ();",
+			},
+		},
+		{
+			name: "down file without up file",
+			files: map[string]string{
+				"1_create_users.down.sql": "DROP TABLE users;",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readMigrations(migrationFS(tt.files), "."); err == nil {
+				t.Fatal("readMigrations() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestMigratorPlan(t *testing.T) {
+	fsys := migrationFS(map[string]string{
+		"1_create_users.up.sql":    "CREATE TABLE users ();",
+		"1_create_users.down.sql":  "DROP TABLE users;",
+		"2_add_email.up.sql":       "ALTER TABLE users ADD email text;",
+		"2_add_email.down.sql":     "ALTER TABLE users DROP email;",
+		"3_create_orders.up.sql":   "CREATE TABLE orders ();",
+		"3_create_orders.down.sql": "DROP TABLE orders;",
+		"4_seed.up.sql":            "INSERT INTO users DEFAULT VALUES;",
+	})
+	m, err := NewMigrator(nil, fsys, MigratorConfig{})
+	if err != nil {
+		t.Fatalf("NewMigrator() error = %v", err)
+	}
+	sums := make(map[int64]string)
+	for _, migration := range m.Migrations() {
+		sums[migration.Version] = migration.checksum
+	}
+	appliedUpTo := func(version int64) map[int64]string {
+		applied := make(map[int64]string)
+		for v := int64(1); v <= version; v++ {
+			applied[v] = sums[v]
+		}
+		return applied
+	}
+
+	tests := []struct {
+		name    string
+		applied map[int64]string
+		target  int64
+		want    []MigrationStep
+		wantErr error
+	}{
+		{
+			name:    "fresh database",
+			applied: map[int64]string{},
+			target:  4,
+			want: []MigrationStep{
+				{Version: 1, Name: "create_users"},
+				{Version: 2, Name: "add_email"},
+				{Version: 3, Name: "create_orders"},
+				{Version: 4, Name: "seed"},
+			},
+		},
+		{
+			name:    "pending up to target",
+			applied: appliedUpTo(1),
+			target:  2,
+			want:    []MigrationStep{{Version: 2, Name: "add_email"}},
+		},
+		{
+			name:    "up to date",
+			applied: appliedUpTo(3),
+			target:  3,
+		},
+		{
+			name:    "down in reverse order",
+			applied: appliedUpTo(3),
+			target:  1,
+			want: []MigrationStep{
+				{Version: 3, Name: "create_orders", Down: true},
+				{Version: 2, Name: "add_email", Down: true},
+			},
+		},
+		{
+			name:    "down to zero",
+			applied: appliedUpTo(2),
+			target:  0,
+			want: []MigrationStep{
+				{Version: 2, Name: "add_email", Down: true},
+				{Version: 1, Name: "create_users", Down: true},
+			},
+		},
+		{
+			name:    "checksum drift",
+			applied: map[int64]string{1: sums[1], 2: "changed"},
+			target:  4,
+			wantErr: ErrChecksumMismatch,
+		},
+		{
+			name:    "applied migration without files",
+			applied: map[int64]string{1: sums[1], 7: "unknown"},
+			target:  1,
+			wantErr: errors.New("applied migration 7 has no files"),
+		},
+		{
+			name:    "down without down file",
+			applied: appliedUpTo(4),
+			target:  3,
+			wantErr: errors.New("migration 4 seed has no down file"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := m.plan(tt.applied, tt.target)
+			if tt.wantErr != nil {
+				if err == nil {
+					t.Fatalf("plan() error = nil, want %v", tt.wantErr)
+				}
+				if !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error() {
+					t.Fatalf("plan() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("plan() error = %v", err)
+			}
+			if !reflect.DeepEqual(steps, tt.want) {
+				t.Errorf("plan() = %+v, want %+v", steps, tt.want)
+			}
+		})
+	}
+}
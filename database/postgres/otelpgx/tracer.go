@@ -0,0 +1,209 @@
+// Package otelpgx records OpenTelemetry spans for pgx, e.g. through
+// postgres.DBConn.Tracers. It is a separate module, so the postgres module does
+// not depend on OpenTelemetry.
+package otelpgx
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const _tracerName = "github.com/slighter12/go-lib/database/postgres/otelpgx"
+
+// OpenTelemetry database semantic convention attributes.
+var _dbSystem = attribute.String("db.system.name", "postgresql")
+
+const (
+	_attrQueryText      = attribute.Key("db.query.text")
+	_attrOperationName  = attribute.Key("db.operation.name")
+	_attrCollectionName = attribute.Key("db.collection.name")
+	_attrBatchSize      = attribute.Key("db.operation.batch.size")
+	_attrStatusCode     = attribute.Key("db.response.status_code")
+	_attrReturnedRows   = attribute.Key("db.response.returned_rows")
+	_attrNamespace      = attribute.Key("db.namespace")
+	_attrServerAddress  = attribute.Key("server.address")
+	_attrServerPort     = attribute.Key("server.port")
+)
+
+// Config defines how Tracer records spans.
+type Config struct {
+	// TracerProvider defaults to the global provider.
+	TracerProvider trace.TracerProvider
+	// OmitQueryText leaves the SQL text out of spans. Bind arguments are never recorded.
+	OmitQueryText bool
+}
+
+// Tracer creates an OpenTelemetry span for every query, batch, copy,
+// prepare, connect and pool acquisition.
+type Tracer struct {
+	tracer        trace.Tracer
+	omitQueryText bool
+}
+
+// NewTracer creates a tracer for postgres.DBConn.Tracers recording OpenTelemetry spans.
+func NewTracer(cfg Config) *Tracer {
+	provider := cfg.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return &Tracer{
+		tracer:        provider.Tracer(_tracerName),
+		omitQueryText: cfg.OmitQueryText,
+	}
+}
+
+func (t *Tracer) start(ctx context.Context, name string, conn *pgx.Conn, attrs ...attribute.KeyValue) context.Context {
+	attrs = append(attrs, _dbSystem)
+	// conn.Config copies the whole configuration, so the address is read from the socket.
+	if conn != nil && conn.PgConn() != nil && conn.PgConn().Conn() != nil {
+		if addr, ok := conn.PgConn().Conn().RemoteAddr().(*net.TCPAddr); ok {
+			attrs = append(attrs, _attrServerAddress.String(addr.IP.String()), _attrServerPort.Int(addr.Port))
+		}
+	}
+	ctx, _ = t.tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+	return ctx
+}
+
+func (t *Tracer) end(ctx context.Context, err error, attrs ...attribute.KeyValue) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrs...)
+	if err != nil {
+		if code := sqlState(err); code != "" {
+			span.SetAttributes(_attrStatusCode.String(code))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (t *Tracer) queryAttrs(sql string) []attribute.KeyValue {
+	operation := operationName(sql)
+	attrs := make([]attribute.KeyValue, 0, 2)
+	if operation != "" {
+		attrs = append(attrs, _attrOperationName.String(operation))
+	}
+	if !t.omitQueryText {
+		attrs = append(attrs, _attrQueryText.String(sql))
+	}
+	return attrs
+}
+
+func serverAttrs(host string, port uint16, database string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{_attrNamespace.String(database)}
+	// Unix socket paths are not server addresses.
+	if host != "" && !strings.HasPrefix(host, "/") {
+		attrs = append(attrs, _attrServerAddress.String(host), _attrServerPort.Int(int(port)))
+	}
+	return attrs
+}
+
+// operationName returns the leading keyword of sql, e.g. "SELECT".
+func operationName(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// spanName follows the semantic conventions: the operation name, or "postgresql".
+func spanName(operation string) string {
+	if operation == "" {
+		return "postgresql"
+	}
+	return operation
+}
+
+// sqlState returns the SQLSTATE of err, or "" when it did not come from the server.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (t *Tracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, spanName(operationName(data.SQL)), conn, t.queryAttrs(data.SQL)...)
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *Tracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, data.Err, _attrReturnedRows.Int64(data.CommandTag.RowsAffected()))
+}
+
+// TraceBatchStart implements pgx.BatchTracer.
+func (t *Tracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, "BATCH", conn, _attrBatchSize.Int(data.Batch.Len()))
+}
+
+// TraceBatchQuery implements pgx.BatchTracer by adding an event per query to the batch span.
+func (t *Tracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	attrs := append(t.queryAttrs(data.SQL), _attrReturnedRows.Int64(data.CommandTag.RowsAffected()))
+	if data.Err != nil {
+		if code := sqlState(data.Err); code != "" {
+			attrs = append(attrs, _attrStatusCode.String(code))
+		}
+		attrs = append(attrs, attribute.String("error", data.Err.Error()))
+	}
+	trace.SpanFromContext(ctx).AddEvent("query", trace.WithAttributes(attrs...))
+}
+
+// TraceBatchEnd implements pgx.BatchTracer.
+func (t *Tracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer.
+func (t *Tracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	table := data.TableName.Sanitize()
+	return t.start(ctx, "COPY "+table, conn, _attrOperationName.String("COPY"), _attrCollectionName.String(table))
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer.
+func (t *Tracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, data.Err, _attrReturnedRows.Int64(data.CommandTag.RowsAffected()))
+}
+
+// TracePrepareStart implements pgx.PrepareTracer.
+func (t *Tracer) TracePrepareStart(ctx context.Context, conn *pgx.Conn, data pgx.TracePrepareStartData) context.Context {
+	return t.start(ctx, "PREPARE", conn, t.queryAttrs(data.SQL)...)
+}
+
+// TracePrepareEnd implements pgx.PrepareTracer.
+func (t *Tracer) TracePrepareEnd(ctx context.Context, _ *pgx.Conn, data pgx.TracePrepareEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceConnectStart implements pgx.ConnectTracer.
+func (t *Tracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	cfg := data.ConnConfig
+	return t.start(ctx, "CONNECT", nil, serverAttrs(cfg.Host, cfg.Port, cfg.Database)...)
+}
+
+// TraceConnectEnd implements pgx.ConnectTracer.
+func (t *Tracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	t.end(ctx, data.Err)
+}
+
+// TraceAcquireStart implements pgxpool.AcquireTracer.
+func (t *Tracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return t.start(ctx, "ACQUIRE", nil)
+}
+
+// TraceAcquireEnd implements pgxpool.AcquireTracer.
+func (t *Tracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	t.end(ctx, data.Err)
+}
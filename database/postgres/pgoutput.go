@@ -0,0 +1,268 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/pkg/errors"
+)
+
+// LSN is a PostgreSQL write-ahead log position.
+type LSN uint64
+
+// String renders the LSN as PostgreSQL does, e.g. "16/B374D848".
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// Microseconds between the Unix and PostgreSQL epochs (2000-01-01 UTC).
+const _postgresEpochOffset = 946684800 * 1000000
+
+func pgTime(micros int64) time.Time {
+	return time.UnixMicro(micros + _postgresEpochOffset)
+}
+
+func pgMicros(t time.Time) int64 {
+	return t.UnixMicro() - _postgresEpochOffset
+}
+
+// messageReader reads the big-endian fields of a protocol message.
+type messageReader struct {
+	data []byte
+	err  error
+}
+
+func (r *messageReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("unexpected end of message")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *messageReader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *messageReader) int16() int16 {
+	if b := r.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *messageReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *messageReader) int64() int64 {
+	if b := r.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *messageReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, c := range r.data {
+		if c == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s
+		}
+	}
+	r.err = errors.New("unterminated string in message")
+	return ""
+}
+
+// relation is the table description sent by pgoutput before its first change.
+type relation struct {
+	schema  string
+	table   string
+	columns []relationColumn
+}
+
+type relationColumn struct {
+	name string
+	oid  uint32
+}
+
+// pgoutputDecoder decodes pgoutput protocol version 1 messages into change events.
+type pgoutputDecoder struct {
+	types     *pgtype.Map
+	relations map[uint32]*relation
+
+	// Current transaction.
+	inTx       bool
+	xid        uint32
+	commitTime time.Time
+	events     []ChangeEvent
+}
+
+func newPgoutputDecoder() *pgoutputDecoder {
+	return &pgoutputDecoder{
+		types:     pgtype.NewMap(),
+		relations: make(map[uint32]*relation),
+	}
+}
+
+// decode processes a single message. It returns the events of the transaction
+// and the LSN to acknowledge once a commit message completes it.
+func (d *pgoutputDecoder) decode(data []byte, lsn LSN) ([]ChangeEvent, LSN, bool, error) {
+	r := &messageReader{data: data}
+	switch r.byte() {
+	case 'B':
+		r.int64() // final LSN
+		d.commitTime = pgTime(r.int64())
+		d.xid = r.uint32()
+		d.inTx, d.events = true, nil
+	case 'C':
+		r.byte()  // flags
+		r.int64() // commit LSN
+		endLSN := LSN(r.int64())
+		if r.err != nil {
+			return nil, 0, false, r.err
+		}
+		events := d.events
+		d.inTx, d.events = false, nil
+		return events, endLSN, true, nil
+	case 'R':
+		relID := r.uint32()
+		rel := &relation{schema: r.string(), table: r.string()}
+		r.byte() // replica identity
+		columns := int(r.int16())
+		for range columns {
+			r.byte() // flags
+			column := relationColumn{name: r.string(), oid: r.uint32()}
+			r.uint32() // type modifier
+			rel.columns = append(rel.columns, column)
+		}
+		if r.err == nil {
+			d.relations[relID] = rel
+		}
+	case 'I':
+		event, err := d.change(r, ChangeInsert, lsn)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if r.byte() == 'N' {
+			event.New, err = d.tuple(r, event.rel)
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		d.events = append(d.events, event.ChangeEvent)
+	case 'U':
+		event, err := d.change(r, ChangeUpdate, lsn)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		kind := r.byte()
+		if kind == 'K' || kind == 'O' {
+			if event.Old, err = d.tuple(r, event.rel); err != nil {
+				return nil, 0, false, err
+			}
+			kind = r.byte()
+		}
+		if kind == 'N' {
+			event.New, err = d.tuple(r, event.rel)
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		d.events = append(d.events, event.ChangeEvent)
+	case 'D':
+		event, err := d.change(r, ChangeDelete, lsn)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if kind := r.byte(); kind == 'K' || kind == 'O' {
+			event.Old, err = d.tuple(r, event.rel)
+		}
+		if err != nil {
+			return nil, 0, false, err
+		}
+		d.events = append(d.events, event.ChangeEvent)
+	}
+	// Truncate, type, origin and logical decoding messages are ignored.
+
+	if r.err != nil {
+		return nil, 0, false, errors.Wrap(r.err, "failed to decode pgoutput message")
+	}
+	return nil, 0, false, nil
+}
+
+type decodedChange struct {
+	ChangeEvent
+	rel *relation
+}
+
+func (d *pgoutputDecoder) change(r *messageReader, kind ChangeKind, lsn LSN) (*decodedChange, error) {
+	relID := r.uint32()
+	rel, ok := d.relations[relID]
+	if !ok {
+		return nil, errors.Errorf("unknown relation %d", relID)
+	}
+	return &decodedChange{
+		ChangeEvent: ChangeEvent{
+			Kind:       kind,
+			Schema:     rel.schema,
+			Table:      rel.table,
+			LSN:        lsn,
+			XID:        d.xid,
+			CommitTime: d.commitTime,
+		},
+		rel: rel,
+	}, nil
+}
+
+// tuple decodes column values; unchanged TOAST values are left out.
+func (d *pgoutputDecoder) tuple(r *messageReader, rel *relation) (map[string]any, error) {
+	columns := int(r.int16())
+	if columns > len(rel.columns) {
+		return nil, errors.Errorf("relation %s.%s has %d columns, got %d", rel.schema, rel.table, len(rel.columns), columns)
+	}
+
+	values := make(map[string]any, columns)
+	for i := range columns {
+		column := rel.columns[i]
+		switch r.byte() {
+		case 'n':
+			values[column.name] = nil
+		case 't':
+			data := r.take(int(r.uint32()))
+			if r.err != nil {
+				return nil, r.err
+			}
+			value, err := d.value(column.oid, data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to decode column %s", column.name)
+			}
+			values[column.name] = value
+		}
+	}
+	return values, r.err
+}
+
+// value decodes a text-format column value; unknown types are returned as strings.
+func (d *pgoutputDecoder) value(oid uint32, data []byte) (any, error) {
+	if typ, ok := d.types.TypeForOID(oid); ok {
+		return typ.Codec.DecodeValue(d.types, oid, pgtype.TextFormatCode, data)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// message builds a replication protocol message from its fields: bytes,
+// int16, uint32 and int64 values are big-endian, strings are NUL-terminated.
+func message(fields ...any) []byte {
+	var data []byte
+	for _, field := range fields {
+		switch field := field.(type) {
+		case byte:
+			data = append(data, field)
+		case int16:
+			data = binary.BigEndian.AppendUint16(data, uint16(field))
+		case uint32:
+			data = binary.BigEndian.AppendUint32(data, field)
+		case int64:
+			data = binary.BigEndian.AppendUint64(data, uint64(field))
+		case string:
+			data = append(append(data, field...), 0)
+		case []byte:
+			data = append(data, field...)
+		default:
+			panic("unsupported message field")
+		}
+	}
+	return data
+}
+
+// textValue is a 't' tuple column.
+func textValue(value string) []byte {
+	return message(byte('t'), uint32(len(value)), []byte(value))
+}
+
+var (
+	_testCommitTime = time.Date(2026, 3, 14, 15, 9, 26, 0, time.UTC).Local()
+
+	_testBegin = message(byte('B'), int64(0x2000), pgMicros(_testCommitTime), uint32(740))
+	// public.orders (id int8, status text, note text)
+	_testRelation = message(byte('R'), uint32(16384), "public", "orders", byte('d'), int16(3),
+		byte(1), "id", uint32(pgtype.Int8OID), uint32(0xFFFFFFFF),
+		byte(0), "status", uint32(pgtype.TextOID), uint32(0xFFFFFFFF),
+		byte(0), "note", uint32(pgtype.TextOID), uint32(0xFFFFFFFF),
+	)
+	_testCommit = message(byte('C'), byte(0), int64(0x2000), int64(0x2100))
+)
+
+func TestPgoutputDecode(t *testing.T) {
+	event := func(kind ChangeKind, lsn LSN, newRow, oldRow map[string]any) ChangeEvent {
+		return ChangeEvent{
+			Kind: kind, Schema: "public", Table: "orders",
+			New: newRow, Old: oldRow,
+			LSN: lsn, XID: 740, CommitTime: _testCommitTime,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		messages [][]byte
+		want     []ChangeEvent
+	}{
+		{
+			name: "insert",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('I'), uint32(16384), byte('N'), int16(3), textValue("1"), textValue("new"), byte('n')),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeInsert, 0x1000, map[string]any{"id": int64(1), "status": "new", "note": nil}, nil),
+			},
+		},
+		{
+			name: "update without key change",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('U'), uint32(16384), byte('N'), int16(3), textValue("1"), textValue("paid"), byte('u')),
+				_testCommit,
+			},
+			// The unchanged TOAST note is left out.
+			want: []ChangeEvent{
+				event(ChangeUpdate, 0x1000, map[string]any{"id": int64(1), "status": "paid"}, nil),
+			},
+		},
+		{
+			name: "update with key change",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('U'), uint32(16384),
+					byte('K'), int16(1), textValue("1"),
+					byte('N'), int16(3), textValue("2"), textValue("paid"), textValue("moved"),
+				),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeUpdate, 0x1000,
+					map[string]any{"id": int64(2), "status": "paid", "note": "moved"},
+					map[string]any{"id": int64(1)},
+				),
+			},
+		},
+		{
+			name: "update with replica identity full",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('U'), uint32(16384),
+					byte('O'), int16(3), textValue("1"), textValue("new"), byte('n'),
+					byte('N'), int16(3), textValue("1"), textValue("paid"), byte('n'),
+				),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeUpdate, 0x1000,
+					map[string]any{"id": int64(1), "status": "paid", "note": nil},
+					map[string]any{"id": int64(1), "status": "new", "note": nil},
+				),
+			},
+		},
+		{
+			name: "delete",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('D'), uint32(16384), byte('K'), int16(1), textValue("1")),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeDelete, 0x1000, nil, map[string]any{"id": int64(1)}),
+			},
+		},
+		{
+			name: "delete with replica identity full",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('D'), uint32(16384), byte('O'), int16(3), textValue("1"), textValue("paid"), byte('u')),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeDelete, 0x1000, nil, map[string]any{"id": int64(1), "status": "paid"}),
+			},
+		},
+		{
+			name: "several changes",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('I'), uint32(16384), byte('N'), int16(2), textValue("1"), textValue("new")),
+				message(byte('D'), uint32(16384), byte('K'), int16(1), textValue("1")),
+				_testCommit,
+			},
+			want: []ChangeEvent{
+				event(ChangeInsert, 0x1000, map[string]any{"id": int64(1), "status": "new"}, nil),
+				event(ChangeDelete, 0x1000, nil, map[string]any{"id": int64(1)}),
+			},
+		},
+		{
+			name:     "empty transaction",
+			messages: [][]byte{_testBegin, _testCommit},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newPgoutputDecoder()
+			for i, data := range tt.messages {
+				events, commitLSN, committed, err := decoder.decode(data, 0x1000)
+				if err != nil {
+					t.Fatalf("decode() message %d error = %v", i, err)
+				}
+				last := i == len(tt.messages)-1
+				if committed != last {
+					t.Fatalf("decode() message %d committed = %v, want %v", i, committed, last)
+				}
+				if !last {
+					if !decoder.inTx {
+						t.Fatalf("decode() message %d left the transaction", i)
+					}
+					continue
+				}
+				if commitLSN != 0x2100 {
+					t.Errorf("decode() commit LSN = %s, want 0/2100", commitLSN)
+				}
+				if !reflect.DeepEqual(events, tt.want) {
+					t.Errorf("decode() events = %+v, want %+v", events, tt.want)
+				}
+				if decoder.inTx {
+					t.Error("decode() still in transaction after commit")
+				}
+			}
+		})
+	}
+}
+
+func TestPgoutputDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages [][]byte
+	}{
+		{
+			name:     "unknown relation",
+			messages: [][]byte{_testBegin, message(byte('I'), uint32(16385), byte('N'), int16(1), textValue("1"))},
+		},
+		{
+			name: "more columns than the relation",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('I'), uint32(16384), byte('N'), int16(4), textValue("1"), textValue("new"), byte('n'), byte('n')),
+			},
+		},
+		{
+			name: "invalid value",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('I'), uint32(16384), byte('N'), int16(1), textValue("one")),
+			},
+		},
+		{
+			name: "truncated value",
+			messages: [][]byte{
+				_testBegin, _testRelation,
+				message(byte('I'), uint32(16384), byte('N'), int16(1), byte('t'), uint32(8), []byte("1")),
+			},
+		},
+		{
+			name:     "truncated commit",
+			messages: [][]byte{_testBegin, message(byte('C'), byte(0), int64(0x2000))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := newPgoutputDecoder()
+			last := len(tt.messages) - 1
+			for i, data := range tt.messages[:last] {
+				if _, _, _, err := decoder.decode(data, 0x1000); err != nil {
+					t.Fatalf("decode() message %d error = %v", i, err)
+				}
+			}
+			if _, _, _, err := decoder.decode(tt.messages[last], 0x1000); err == nil {
+				t.Fatal("decode() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestStreamPositionKeepalive(t *testing.T) {
+	keepalive := func(walEnd int64, replyRequested byte) *messageReader {
+		return &messageReader{data: message(walEnd, pgMicros(_testCommitTime), replyRequested)}
+	}
+
+	decoder := newPgoutputDecoder()
+	pos := streamPosition{confirmed: 0x2100, serverWALEnd: 0x2100}
+	steps := []struct {
+		name         string
+		message      []byte
+		keepalive    *messageReader
+		wantReply    bool
+		wantPosition streamPosition
+	}{
+		{
+			name:         "idle server advances confirmed",
+			keepalive:    keepalive(0x3000, 0),
+			wantPosition: streamPosition{confirmed: 0x3000, serverWALEnd: 0x3000},
+		},
+		{
+			name:         "reply requested",
+			keepalive:    keepalive(0x3100, 1),
+			wantReply:    true,
+			wantPosition: streamPosition{confirmed: 0x3100, serverWALEnd: 0x3100},
+		},
+		{
+			name:         "older WAL end is ignored",
+			keepalive:    keepalive(0x2000, 0),
+			wantPosition: streamPosition{confirmed: 0x3100, serverWALEnd: 0x3100},
+		},
+		{
+			name:         "open transaction holds confirmed",
+			message:      _testBegin,
+			keepalive:    keepalive(0x4000, 0),
+			wantPosition: streamPosition{confirmed: 0x3100, serverWALEnd: 0x4000},
+		},
+		{
+			name:         "committed transaction releases confirmed",
+			message:      message(byte('C'), byte(0), int64(0x4000), int64(0x4100)),
+			keepalive:    keepalive(0x4100, 0),
+			wantPosition: streamPosition{confirmed: 0x4100, serverWALEnd: 0x4100},
+		},
+	}
+
+	for _, step := range steps {
+		if step.message != nil {
+			if _, _, _, err := decoder.decode(step.message, 0x1000); err != nil {
+				t.Fatalf("%s: decode() error = %v", step.name, err)
+			}
+		}
+		reply, err := pos.keepalive(step.keepalive, decoder.inTx)
+		if err != nil {
+			t.Fatalf("%s: keepalive() error = %v", step.name, err)
+		}
+		if reply != step.wantReply {
+			t.Errorf("%s: keepalive() reply = %v, want %v", step.name, reply, step.wantReply)
+		}
+		if pos != step.wantPosition {
+			t.Errorf("%s: position = %+v, want %+v", step.name, pos, step.wantPosition)
+		}
+	}
+
+	if _, err := pos.keepalive(&messageReader{data: message(int64(0x5000))}, false); err == nil {
+		t.Error("keepalive() of a truncated message error = nil, want error")
+	}
+}
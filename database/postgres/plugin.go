@@ -0,0 +1,29 @@
+package postgres
+
+import (
+	"gorm.io/gorm"
+)
+
+const _clientPluginName = "postgres:client"
+
+// clientPlugin keeps the client configuration on the GORM DB, so helpers taking
+// a *gorm.DB can tell how it was configured.
+type clientPlugin struct {
+	conn   *DBConn
+	preset *PresetConfig
+}
+
+func (p *clientPlugin) Name() string {
+	return _clientPluginName
+}
+
+func (p *clientPlugin) Initialize(*gorm.DB) error {
+	return nil
+}
+
+// clientPluginOf returns the client configuration of db, or nil when db was
+// not created by this package.
+func clientPluginOf(db *gorm.DB) *clientPlugin {
+	plugin, _ := db.Config.Plugins[_clientPluginName].(*clientPlugin)
+	return plugin
+}
@@ -1,31 +1,21 @@
 package postgres
 
 import (
-	"log"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
-	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/jackc/pgx/v5/stdlib"
-	"github.com/pkg/errors"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/plugin/dbresolver"
 )
 
 const (
 	_defaultMaxOpenConns = 25
 	_defaultMaxIdleConns = 25
 	_defaultMaxLifeTime  = 5 * time.Minute
-)
-
-type Preset string
-
-const (
-	PresetSupabaseTransaction Preset = "supabase_transaction"
+	_defaultMaxIdleTime  = time.Hour
 )
 
 // DBConn combines primary and replica configurations.
@@ -33,13 +23,29 @@ type DBConn struct {
 	// Primary configuration.
 	Master ConnectionConfig `json:"master" yaml:"master"`
 
-	// Replica configuration list.
+	// Replica configuration list. Empty UserName and Port fields inherit the
+	// master's values, and an empty Password does when the replica connects as
+	// the master's user; see EffectiveReplicas.
 	Replicas []ConnectionConfig `json:"replicas" yaml:"replicas"`
+	// Compact replica list of "host" or "host:port" entries, added after Replicas
+	// and inheriting every other setting from the master.
+	ReplicaHosts []string `json:"replicaHosts" yaml:"replicaHosts"`
+	// Replica selection policy; defaults to ReplicaPolicyRandom.
+	// ReplicaPolicyLeastLatency enables health checks to measure ping times.
+	ReplicaPolicy ReplicaPolicy `json:"replicaPolicy" yaml:"replicaPolicy"`
 
 	// Connection pool settings.
-	MaxIdleConns    int           `json:"maxIdleConns" yaml:"maxIdleConns"`
 	MaxOpenConns    int           `json:"maxOpenConns" yaml:"maxOpenConns"`
 	ConnMaxLifetime time.Duration `json:"connMaxLifetime" yaml:"connMaxLifetime"`
+	// Idle connections are closed after this long, on the master and every replica; defaults to 1h.
+	ConnMaxIdleTime time.Duration `json:"connMaxIdleTime" yaml:"connMaxIdleTime"`
+	// Idle connections kept open and ready per endpoint; none by default.
+	MinIdleConns int `json:"minIdleConns" yaml:"minIdleConns"`
+	// Idle connections kept at most per endpoint; connections released beyond it
+	// are closed. Defaults to 25.
+	MaxIdleConns int `json:"maxIdleConns" yaml:"maxIdleConns"`
+	// Pool settings for every replica; unset fields fall back to the settings above.
+	ReplicaPool *PoolConfig `json:"replicaPool" yaml:"replicaPool"`
 
 	// Database name.
 	Database string `json:"database" yaml:"database"`
@@ -48,6 +54,11 @@ type DBConn struct {
 	Schema     string `json:"schema" yaml:"schema"`
 	SearchPath string `json:"searchPath" yaml:"searchPath"`
 	SSLMode    string `json:"sslMode" yaml:"sslMode"`
+	// TLS certificates applied to the master and every replica.
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
+	// Supplies rotating credentials before each new connection;
+	// overrides the static UserName and Password when set.
+	CredentialProvider CredentialProvider `json:"-" yaml:"-"`
 
 	// PostgreSQL timeout settings.
 	StatementTimeout                time.Duration `json:"statementTimeout" yaml:"statementTimeout"`                               // statement_timeout
@@ -59,8 +70,38 @@ type DBConn struct {
 	RuntimeParams     map[string]string `json:"runtimeParams" yaml:"runtimeParams"`
 	HealthCheckPeriod time.Duration     `json:"healthCheckPeriod" yaml:"healthCheckPeriod"`
 
+	// Read-your-writes settings for contexts created with WithSession.
+	Consistency *ConsistencyConfig `json:"consistency" yaml:"consistency"`
+
+	// Schema-per-tenant settings for contexts created with WithTenant.
+	Tenant *TenantConfig `json:"tenant" yaml:"tenant"`
+
+	// Appends sqlcommenter-style comments to every statement; off when nil.
+	QueryComment *QueryCommentConfig `json:"queryComment" yaml:"queryComment"`
+
+	// Trace every query of the master and replicas, e.g. NewSlogTracer or otelpgx.NewTracer.
+	// Tracers also implementing pgx.BatchTracer, pgx.CopyFromTracer, pgx.PrepareTracer,
+	// pgx.ConnectTracer or pgxpool.AcquireTracer receive those events too.
+	Tracers []pgx.QueryTracer `json:"-" yaml:"-"`
+
+	// Replicas whose replay lag exceeds MaxReplicationLag are skipped for reads.
+	// Lag is measured every ReplicationLagCheckPeriod (default 5s) as the time since the
+	// master wrote the oldest WAL a replica has not replayed, to the precision of the period.
+	MaxReplicationLag         time.Duration `json:"maxReplicationLag" yaml:"maxReplicationLag"`
+	ReplicationLagCheckPeriod time.Duration `json:"replicationLagCheckPeriod" yaml:"replicationLagCheckPeriod"`
+
+	// Verify connectivity of the master and every replica on startup;
+	// nil skips verification.
+	Verify *VerifyConfig `json:"verify" yaml:"verify"`
+
+	// Called when the master or a replica changes health state.
+	// Unhealthy replicas are removed from read routing until they recover.
+	// Health and lag checks run in the background until Client.Close, or until
+	// the *sql.DB of a DB returned by New is closed.
+	OnHealthChange func(HealthEvent) `json:"-" yaml:"-"`
+
 	// Preset configuration for default connection behavior.
-	// Optional values: "", PresetSupabaseTransaction.
+	// Optional values: "", a built-in Preset constant, or a name added with RegisterPreset.
 	Preset Preset `json:"preset" yaml:"preset"`
 	// GORM settings that override preset behavior.
 	GORM *GORMConfig `json:"gorm" yaml:"gorm"`
@@ -74,6 +115,17 @@ type ConnectionConfig struct {
 	Port     string `json:"port" yaml:"port"`
 	UserName string `json:"username" yaml:"username"`
 	Password string `json:"password" yaml:"password"`
+	// Additional candidate hosts tried in order after Host, as "host" or "host:port".
+	// Entries without a port use Port.
+	Hosts []string `json:"hosts" yaml:"hosts"`
+	// target_session_attrs used to pick a host among the candidates.
+	// Defaults to "read-write" for the master and "prefer-standby" for replicas when Hosts is set.
+	TargetSessionAttrs string `json:"targetSessionAttrs" yaml:"targetSessionAttrs"`
+	// Relative weight for ReplicaPolicyWeighted; defaults to 1.
+	Weight int `json:"weight" yaml:"weight"`
+	// Pool settings for this endpoint; unset fields fall back to
+	// DBConn.ReplicaPool for replicas and to the DBConn pool settings.
+	Pool *PoolConfig `json:"pool" yaml:"pool"`
 }
 
 // GORMConfig defines behavior settings at the GORM layer.
@@ -93,12 +145,117 @@ type PGXConfig struct {
 	StatementCacheCap *int `json:"statementCacheCap" yaml:"statementCacheCap"`
 }
 
+const _defaultPort = "5432"
+
+// EffectiveReplicas returns the replicas as they are connected to: Replicas
+// followed by ReplicaHosts, with empty UserName and Port taken from the master.
+// An empty Password is taken from the master when the resulting user name is the master's.
+// Print the result to inspect it; ConnectionConfig.String redacts passwords.
+func (c *DBConn) EffectiveReplicas() []ConnectionConfig {
+	replicas := make([]ConnectionConfig, 0, len(c.Replicas)+len(c.ReplicaHosts))
+	replicas = append(replicas, c.Replicas...)
+	for _, replicaHost := range c.ReplicaHosts {
+		var replica ConnectionConfig
+		if host, port, err := net.SplitHostPort(replicaHost); err == nil {
+			replica.Host, replica.Port = host, port
+		} else {
+			replica.Host = replicaHost
+		}
+		replicas = append(replicas, replica)
+	}
+
+	for i := range replicas {
+		replica := &replicas[i]
+		if replica.UserName == "" {
+			replica.UserName = c.Master.UserName
+		}
+		if replica.Password == "" && replica.UserName == c.Master.UserName {
+			replica.Password = c.Master.Password
+		}
+		if replica.Port == "" {
+			replica.Port = c.Master.Port
+		}
+	}
+	return replicas
+}
+
+// String describes the connection for logs, with the password redacted.
+func (c ConnectionConfig) String() string {
+	var s strings.Builder
+	appendDSNParam(&s, "host", c.address())
+	appendDSNParam(&s, "user", c.UserName)
+	if c.Password != "" {
+		appendDSNParam(&s, "password", "[REDACTED]")
+	}
+	if c.TargetSessionAttrs != "" {
+		appendDSNParam(&s, "target_session_attrs", c.TargetSessionAttrs)
+	}
+	if c.Weight > 0 {
+		appendDSNParam(&s, "weight", strconv.Itoa(c.Weight))
+	}
+	return s.String()
+}
+
+// address returns the host and port of the connection,
+// listing every candidate when multiple hosts are configured.
+func (c *ConnectionConfig) address() string {
+	if len(c.Hosts) == 0 {
+		if c.Port == "" {
+			return c.Host
+		}
+		return net.JoinHostPort(c.Host, c.Port)
+	}
+
+	hosts, ports := c.candidates()
+	addrs := make([]string, len(hosts))
+	for i := range hosts {
+		addrs[i] = net.JoinHostPort(hosts[i], ports[i])
+	}
+	return strings.Join(addrs, ",")
+}
+
+// candidates returns the host and port of every candidate in connection order.
+func (c *ConnectionConfig) candidates() (hosts, ports []string) {
+	defaultPort := c.Port
+	if defaultPort == "" {
+		defaultPort = _defaultPort
+	}
+
+	if c.Host != "" {
+		hosts = append(hosts, c.Host)
+		ports = append(ports, defaultPort)
+	}
+	for _, candidate := range c.Hosts {
+		host, port, err := net.SplitHostPort(candidate)
+		if err != nil {
+			host, port = candidate, defaultPort
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+	return hosts, ports
+}
+
+// withTargetSessionAttrs returns a copy of c using attrs when multiple hosts are
+// configured and no explicit target_session_attrs is set.
+func (c ConnectionConfig) withTargetSessionAttrs(attrs string) ConnectionConfig {
+	if len(c.Hosts) > 0 && c.TargetSessionAttrs == "" {
+		c.TargetSessionAttrs = attrs
+	}
+	return c
+}
+
 // DSN generates a PostgreSQL connection string.
 func (c *ConnectionConfig) DSN(cfg *DBConn) string {
 	sslMode := "disable"
 	if cfg.SSLMode != "" {
 		sslMode = cfg.SSLMode
 	}
+	// libpq treats require as verify-ca once a root CA is known;
+	// pgx only does so for sslrootcert, so mirror it for inline PEM.
+	if sslMode == "require" && cfg.TLS.hasInlineRootCert() {
+		sslMode = "verify-ca"
+	}
 
 	searchPath := "public"
 	if cfg.SearchPath != "" {
@@ -106,12 +263,24 @@ func (c *ConnectionConfig) DSN(cfg *DBConn) string {
 	}
 
 	var dsn strings.Builder
-	appendDSNParam(&dsn, "host", c.Host)
-	appendDSNParam(&dsn, "port", c.Port)
+	if len(c.Hosts) == 0 {
+		appendDSNParam(&dsn, "host", c.Host)
+		if c.Port != "" {
+			appendDSNParam(&dsn, "port", c.Port)
+		}
+	} else {
+		hosts, ports := c.candidates()
+		appendDSNParam(&dsn, "host", strings.Join(hosts, ","))
+		appendDSNParam(&dsn, "port", strings.Join(ports, ","))
+	}
+	if c.TargetSessionAttrs != "" {
+		appendDSNParam(&dsn, "target_session_attrs", c.TargetSessionAttrs)
+	}
 	appendDSNParam(&dsn, "user", c.UserName)
 	appendDSNParam(&dsn, "password", c.Password)
 	appendDSNParam(&dsn, "dbname", cfg.Database)
 	appendDSNParam(&dsn, "sslmode", sslMode)
+	cfg.TLS.appendDSNParams(&dsn)
 	appendDSNParam(&dsn, "search_path", searchPath)
 
 	// Apply timeout settings.
@@ -130,15 +299,25 @@ func (c *ConnectionConfig) DSN(cfg *DBConn) string {
 		appendDSNParam(&dsn, "application_name", cfg.ApplicationName)
 	}
 
-	// Add runtime parameters.
-	if len(cfg.RuntimeParams) > 0 {
-		keys := make([]string, 0, len(cfg.RuntimeParams))
-		for key := range cfg.RuntimeParams {
+	// Add runtime parameters, falling back to preset defaults.
+	runtimeParams := cfg.RuntimeParams
+	if preset, ok := LookupPreset(cfg.Preset); ok && len(preset.RuntimeParams) > 0 {
+		runtimeParams = make(map[string]string, len(preset.RuntimeParams)+len(cfg.RuntimeParams))
+		for key, value := range preset.RuntimeParams {
+			runtimeParams[key] = value
+		}
+		for key, value := range cfg.RuntimeParams {
+			runtimeParams[key] = value
+		}
+	}
+	if len(runtimeParams) > 0 {
+		keys := make([]string, 0, len(runtimeParams))
+		for key := range runtimeParams {
 			keys = append(keys, key)
 		}
 		sort.Strings(keys)
 		for _, key := range keys {
-			appendDSNParam(&dsn, key, cfg.RuntimeParams[key])
+			appendDSNParam(&dsn, key, runtimeParams[key])
 		}
 	}
 
@@ -177,117 +356,29 @@ func escapeDSNValue(value string) string {
 	return escaped.String()
 }
 
-func setupConnPool(db *gorm.DB, conn *DBConn) error {
-	sqlDB, err := db.DB()
-	if err != nil {
-		return errors.Wrap(err, "failed to get underlying DB")
-	}
-
-	maxIdleConns := _defaultMaxIdleConns
-	if conn.MaxIdleConns > 0 {
-		maxIdleConns = conn.MaxIdleConns
-	}
-
-	maxOpenConns := _defaultMaxOpenConns
-	if conn.MaxOpenConns > 0 {
-		maxOpenConns = conn.MaxOpenConns
-	}
-
-	maxLifeTime := _defaultMaxLifeTime
-	if conn.ConnMaxLifetime > 0 {
-		maxLifeTime = conn.ConnMaxLifetime
-	}
-
-	sqlDB.SetMaxIdleConns(maxIdleConns)
-	sqlDB.SetMaxOpenConns(maxOpenConns)
-	sqlDB.SetConnMaxLifetime(maxLifeTime)
-
-	return nil
-}
-
 // New creates a new PostgreSQL database connection.
+// Closing the *sql.DB returned by DB() stops health and replication lag checks
+// and closes the pools, waiting for running queries. Use NewClient and Close
+// for a shutdown bounded by a deadline or access to the pgx pools.
 func New(conn *DBConn) (*gorm.DB, error) {
-	preset := resolvePreset(conn.Preset)
-	if conn.Preset != "" && preset == "" {
-		log.Printf("postgres: unknown preset %q, using default behavior", conn.Preset)
-	}
-
-	// Create primary connection.
-	masterConfig, err := pgxpool.ParseConfig(conn.Master.DSN(conn))
+	client, err := newClient(conn, true)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse master config")
-	}
-
-	// Set pgx-specific settings.
-	if conn.HealthCheckPeriod > 0 {
-		masterConfig.HealthCheckPeriod = conn.HealthCheckPeriod
-	}
-
-	// Apply PGX settings.
-	applyPGXConfig(masterConfig.ConnConfig, conn, preset)
-
-	masterDB := stdlib.OpenDB(*masterConfig.ConnConfig)
-	// Apply GORM settings.
-	gormConfig := &gorm.Config{}
-	applyGORMConfig(gormConfig, conn, preset)
-	dbBase, err := gorm.Open(postgres.New(postgres.Config{
-		Conn: masterDB,
-	}), gormConfig)
-
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create master connection")
-	}
-
-	// Configure read/write splitting when replicas are provided.
-	if len(conn.Replicas) > 0 {
-		var replicas []gorm.Dialector
-		for _, replica := range conn.Replicas {
-			replicaConfig, err := pgxpool.ParseConfig(replica.DSN(conn))
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to parse replica config")
-			}
-
-			// Set pgx-specific settings.
-			if conn.HealthCheckPeriod > 0 {
-				replicaConfig.HealthCheckPeriod = conn.HealthCheckPeriod
-			}
-			applyPGXConfig(replicaConfig.ConnConfig, conn, preset)
-
-			replicaDB := stdlib.OpenDB(*replicaConfig.ConnConfig)
-			replicas = append(replicas, postgres.New(postgres.Config{
-				Conn: replicaDB,
-			}))
-		}
-
-		// Register dbresolver plugin.
-		err = dbBase.Use(dbresolver.Register(dbresolver.Config{
-			Replicas: replicas,
-			Policy:   dbresolver.RandomPolicy{},
-		}).SetConnMaxIdleTime(time.Hour))
-
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to register dbresolver")
-		}
-	}
-
-	if err := setupConnPool(dbBase, conn); err != nil {
 		return nil, err
 	}
 
-	return dbBase, nil
+	return client.DB, nil
 }
 
 // applyGORMConfig applies GORM settings.
-func applyGORMConfig(cfg *gorm.Config, conn *DBConn, preset Preset) {
-	if conn.GORM == nil && preset == "" {
-		return
-	}
+func applyGORMConfig(cfg *gorm.Config, conn *DBConn, preset *PresetConfig) {
 	// Resolve preset.
 	skipDefaultTx := cfg.SkipDefaultTransaction
 	prepareStmt := cfg.PrepareStmt
-	if preset == PresetSupabaseTransaction {
-		skipDefaultTx = true
-		prepareStmt = false
+	if preset.GORM.SkipDefaultTransaction != nil {
+		skipDefaultTx = *preset.GORM.SkipDefaultTransaction
+	}
+	if preset.GORM.PrepareStmt != nil {
+		prepareStmt = *preset.GORM.PrepareStmt
 	}
 	// Override with explicit config.
 	if conn.GORM != nil {
@@ -303,38 +394,27 @@ func applyGORMConfig(cfg *gorm.Config, conn *DBConn, preset Preset) {
 }
 
 // applyPGXConfig applies PGX settings.
-func applyPGXConfig(cfg *pgx.ConnConfig, conn *DBConn, preset Preset) {
-	hasPreset := preset != ""
+func applyPGXConfig(cfg *pgx.ConnConfig, conn *DBConn, preset *PresetConfig) {
+	hasPresetStatementCacheCap := preset.PGX.StatementCacheCap != nil
 	hasExplicitStatementCacheCap := conn.PGX != nil && conn.PGX.StatementCacheCap != nil
-	if !hasPreset && !hasExplicitStatementCacheCap {
+	if !hasPresetStatementCacheCap && !hasExplicitStatementCacheCap {
 		return
 	}
 	// Resolve preset.
 	statementCacheCap := cfg.StatementCacheCapacity
-	if preset == PresetSupabaseTransaction {
-		statementCacheCap = 0
+	if hasPresetStatementCacheCap {
+		statementCacheCap = *preset.PGX.StatementCacheCap
 	}
 	// Override with explicit config.
-	if conn.PGX != nil {
-		if conn.PGX.StatementCacheCap != nil {
-			statementCacheCap = *conn.PGX.StatementCacheCap
-		}
+	if hasExplicitStatementCacheCap {
+		statementCacheCap = *conn.PGX.StatementCacheCap
 	}
 
 	// Set statement cache capacity.
 	// A value of 0 disables the statement cache.
 	cfg.StatementCacheCapacity = statementCacheCap
-	if statementCacheCap == 0 && (preset == PresetSupabaseTransaction || hasExplicitStatementCacheCap) {
+	if statementCacheCap == 0 {
 		// Disable auto-prepare behavior for transaction poolers.
 		cfg.DefaultQueryExecMode = pgx.QueryExecModeExec
 	}
 }
-
-func resolvePreset(preset Preset) Preset {
-	switch preset {
-	case "", PresetSupabaseTransaction:
-		return preset
-	default:
-		return ""
-	}
-}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Preset names a registered set of connection defaults.
+type Preset string
+
+const (
+	// Supabase pooler (Supavisor) in transaction mode.
+	PresetSupabaseTransaction Preset = "supabase_transaction"
+	// PgBouncer with pool_mode=transaction.
+	PresetPgBouncerTransaction Preset = "pgbouncer_transaction"
+	// PgBouncer with pool_mode=session.
+	PresetPgBouncerSession Preset = "pgbouncer_session"
+	// Neon pooled endpoint (PgBouncer in transaction mode).
+	PresetNeonPooled Preset = "neon_pooled"
+	// Amazon RDS Proxy.
+	PresetRDSProxy Preset = "rds_proxy"
+	// CockroachDB.
+	PresetCockroachDB Preset = "cockroachdb"
+)
+
+// PresetConfig describes the defaults a preset applies.
+// Explicit DBConn settings always take precedence over preset defaults.
+type PresetConfig struct {
+	GORM GORMConfig
+	PGX  PGXConfig
+	// Runtime parameters sent on connect unless DBConn.RuntimeParams sets the same key.
+	RuntimeParams map[string]string
+	// Pool defaults used when the DBConn pool settings are zero.
+	Pool PoolConfig
+	// TransactionPooling marks presets that run behind a transaction pooler, where
+	// session state such as SET or session advisory locks does not persist.
+	TransactionPooling bool
+}
+
+// PoolConfig defines connection pool settings.
+type PoolConfig struct {
+	MaxOpenConns    int           `json:"maxOpenConns" yaml:"maxOpenConns"`
+	ConnMaxLifetime time.Duration `json:"connMaxLifetime" yaml:"connMaxLifetime"`
+	ConnMaxIdleTime time.Duration `json:"connMaxIdleTime" yaml:"connMaxIdleTime"`
+	// Idle connections kept open and ready; none by default.
+	MinIdleConns int `json:"minIdleConns" yaml:"minIdleConns"`
+	// Idle connections kept at most; connections released beyond it are closed.
+	MaxIdleConns int `json:"maxIdleConns" yaml:"maxIdleConns"`
+}
+
+var (
+	presetsMu sync.RWMutex
+	presets   = map[Preset]PresetConfig{
+		PresetSupabaseTransaction:  transactionPoolerPreset(),
+		PresetPgBouncerTransaction: transactionPoolerPreset(),
+		PresetNeonPooled:           transactionPoolerPreset(),
+		PresetPgBouncerSession: {
+			// Release server connections before PgBouncer's server_idle_timeout.
+			Pool: PoolConfig{ConnMaxIdleTime: 5 * time.Minute},
+		},
+		PresetRDSProxy: {
+			// Prepared statements pin the client to a backend connection.
+			PGX: PGXConfig{StatementCacheCap: ptr(0)},
+			// RDS Proxy closes client connections idle for longer than its idle client timeout.
+			Pool: PoolConfig{ConnMaxIdleTime: 10 * time.Minute},
+		},
+		PresetCockroachDB: {
+			// Recycle connections so load rebalances across nodes.
+			Pool: PoolConfig{ConnMaxLifetime: 30 * time.Minute},
+		},
+	}
+)
+
+// transactionPoolerPreset disables everything that relies on session state.
+func transactionPoolerPreset() PresetConfig {
+	return PresetConfig{
+		GORM: GORMConfig{
+			SkipDefaultTransaction: ptr(true),
+			PrepareStmt:            ptr(false),
+		},
+		PGX: PGXConfig{
+			StatementCacheCap: ptr(0),
+		},
+		TransactionPooling: true,
+	}
+}
+
+// RegisterPreset adds a preset to the registry.
+// It returns an error when the name is empty or already registered.
+func RegisterPreset(name Preset, cfg PresetConfig) error {
+	if name == "" {
+		return errors.New("preset name is required")
+	}
+
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+
+	if _, ok := presets[name]; ok {
+		return errors.Errorf("preset %q already registered", name)
+	}
+	presets[name] = cfg
+	return nil
+}
+
+// LookupPreset returns the registered preset with the given name.
+func LookupPreset(name Preset) (PresetConfig, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+
+	cfg, ok := presets[name]
+	return cfg, ok
+}
+
+// resolvePreset returns the preset configuration; an empty name yields no defaults.
+func resolvePreset(name Preset) (*PresetConfig, error) {
+	if name == "" {
+		return &PresetConfig{}, nil
+	}
+
+	cfg, ok := LookupPreset(name)
+	if !ok {
+		return nil, errors.Errorf("unknown preset %q", name)
+	}
+	return &cfg, nil
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,180 @@
+package postgres
+
+import (
+	"database/sql"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// ReplicaPolicy selects how reads are balanced across eligible replicas.
+type ReplicaPolicy string
+
+const (
+	ReplicaPolicyRandom     ReplicaPolicy = "random"
+	ReplicaPolicyRoundRobin ReplicaPolicy = "round_robin"
+	// Picks replicas proportionally to ConnectionConfig.Weight.
+	ReplicaPolicyWeighted ReplicaPolicy = "weighted"
+	// Picks the replica with the lowest ping time, measured when the client is
+	// created and every HealthCheckPeriod (default 10s) until it is closed.
+	ReplicaPolicyLeastLatency ReplicaPolicy = "least_latency"
+)
+
+const _defaultLatencyCheckPeriod = 10 * time.Second
+
+// replica tracks the routing state of a single replica.
+type replica struct {
+	index   int
+	host    string
+	weight  int
+	pool    *pgxpool.Pool
+	db      *sql.DB
+	healthy atomic.Bool
+	lagging atomic.Bool
+	// Last measured replication lag in nanoseconds.
+	lag atomic.Int64
+	// Last measured ping time in nanoseconds.
+	latency atomic.Int64
+	// Last observed replay LSN.
+	replayLSN atomic.Int64
+}
+
+// eligible reports whether the replica may receive reads.
+func (r *replica) eligible() bool {
+	return r.healthy.Load() && !r.lagging.Load()
+}
+
+// router is a dbresolver policy that only routes reads to eligible replicas
+// and falls back to the master when none are available.
+type router struct {
+	master   *sql.DB
+	replicas []*replica
+	byPool   map[gorm.ConnPool]*replica
+	policy   ReplicaPolicy
+	next     atomic.Uint64
+	// Read-your-writes settings; nil disables session pinning.
+	consistency *ConsistencyConfig
+	masterPool  *pgxpool.Pool
+}
+
+func newRouter(master *sql.DB, policy ReplicaPolicy) (*router, error) {
+	switch policy {
+	case "":
+		policy = ReplicaPolicyRandom
+	case ReplicaPolicyRandom, ReplicaPolicyRoundRobin, ReplicaPolicyWeighted, ReplicaPolicyLeastLatency:
+	default:
+		return nil, errors.Errorf("unknown replica policy %q", policy)
+	}
+
+	return &router{
+		master: master,
+		byPool: make(map[gorm.ConnPool]*replica),
+		policy: policy,
+	}, nil
+}
+
+// addReplica registers a replica for routing; replicas start healthy.
+func (r *router) addReplica(cc ConnectionConfig, pool *pgxpool.Pool, db *sql.DB) {
+	rep := &replica{
+		index:  len(r.replicas),
+		host:   cc.address(),
+		weight: max(cc.Weight, 1),
+		pool:   pool,
+		db:     db,
+	}
+	rep.healthy.Store(true)
+	r.replicas = append(r.replicas, rep)
+	r.byPool[db] = rep
+}
+
+// hasEligible reports whether at least one replica may receive reads.
+func (r *router) hasEligible() bool {
+	for _, rep := range r.replicas {
+		if rep.eligible() {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve implements dbresolver.Policy.
+func (r *router) Resolve(connPools []gorm.ConnPool) gorm.ConnPool {
+	eligible := make([]*replica, 0, len(connPools))
+	for _, connPool := range connPools {
+		if rep, ok := r.byPool[connPool]; ok && rep.eligible() {
+			eligible = append(eligible, rep)
+		}
+	}
+
+	switch len(eligible) {
+	case 0:
+		return r.master
+	case 1:
+		return eligible[0].db
+	default:
+		return r.pick(eligible).db
+	}
+}
+
+func (r *router) pick(eligible []*replica) *replica {
+	switch r.policy {
+	case ReplicaPolicyRoundRobin:
+		return eligible[r.next.Add(1)%uint64(len(eligible))]
+	case ReplicaPolicyWeighted:
+		total := 0
+		for _, rep := range eligible {
+			total += rep.weight
+		}
+		n := rand.IntN(total)
+		for _, rep := range eligible {
+			if n < rep.weight {
+				return rep
+			}
+			n -= rep.weight
+		}
+		return eligible[len(eligible)-1]
+	case ReplicaPolicyLeastLatency:
+		best := eligible[0]
+		for _, rep := range eligible[1:] {
+			if rep.latency.Load() < best.latency.Load() {
+				best = rep
+			}
+		}
+		return best
+	default:
+		return eligible[rand.IntN(len(eligible))]
+	}
+}
+
+// register installs callbacks that send reads to the master when no replica is eligible.
+// dbresolver skips the policy when only one replica exists, so the policy alone is not enough.
+func (r *router) register(db *gorm.DB) error {
+	if err := db.Callback().Query().Before("gorm:query").Register("postgres:replica_router", r.route); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("postgres:replica_router", r.route); err != nil {
+		return err
+	}
+	if err := db.Callback().Raw().Before("gorm:raw").Register("postgres:replica_router", r.route); err != nil {
+		return err
+	}
+
+	if r.consistency != nil {
+		return r.registerSession(db)
+	}
+	return nil
+}
+
+func (r *router) route(db *gorm.DB) {
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		return
+	}
+	if !r.hasEligible() || r.pinned(db.Statement.Context) {
+		dbresolver.Write.ModifyStatement(db.Statement)
+	}
+}
@@ -0,0 +1,142 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const _currentWALLSNQuery = `SELECT (pg_current_wal_lsn() - '0/0')::int8`
+
+// _defaultLSNWindow caps how long TrackLSN pins reads when Window is not set.
+const _defaultLSNWindow = 30 * time.Second
+
+// ConsistencyConfig defines read-your-writes behavior for contexts created with WithSession.
+type ConsistencyConfig struct {
+	// Reads stay on the master for this long after a write in the same session.
+	// Required unless TrackLSN is set, where it defaults to 30s.
+	Window time.Duration `json:"window" yaml:"window"`
+	// Release the pin early once every eligible replica has replayed past the write LSN.
+	// Writes inside explicit transactions are only pinned by Window.
+	TrackLSN bool `json:"trackLSN" yaml:"trackLSN"`
+}
+
+// validate rejects settings that would never pin a read.
+func (c *ConsistencyConfig) validate() error {
+	if c.Window < 0 {
+		return errors.Errorf("negative consistency window %s", c.Window)
+	}
+	if c.Window == 0 && !c.TrackLSN {
+		return errors.New("consistency window is required unless TrackLSN is set")
+	}
+	return nil
+}
+
+// window returns how long reads stay pinned after a write at most.
+func (c *ConsistencyConfig) window() time.Duration {
+	if c.Window == 0 {
+		return _defaultLSNWindow
+	}
+	return c.Window
+}
+
+type sessionKey struct{}
+
+// session records the last write made through a request context.
+type session struct {
+	mu        sync.Mutex
+	writtenAt time.Time
+	lsn       int64
+}
+
+// WithSession returns a context that tracks writes for read-your-writes consistency.
+// Reads made with the returned context are pinned to the master after a write,
+// as configured by DBConn.Consistency.
+func WithSession(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(sessionKey{}).(*session); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionKey{}, &session{})
+}
+
+func sessionFrom(ctx context.Context) *session {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(sessionKey{}).(*session)
+	return s
+}
+
+func (s *session) markWrite(lsn int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writtenAt = time.Now()
+	// Master LSNs only grow, so the latest write wins; zero disables early release.
+	s.lsn = lsn
+}
+
+func (s *session) lastWrite() (time.Time, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writtenAt, s.lsn
+}
+
+// pinned reports whether reads in ctx must go to the master.
+func (r *router) pinned(ctx context.Context) bool {
+	if r.consistency == nil {
+		return false
+	}
+	s := sessionFrom(ctx)
+	if s == nil {
+		return false
+	}
+
+	writtenAt, lsn := s.lastWrite()
+	if writtenAt.IsZero() || time.Since(writtenAt) >= r.consistency.window() {
+		return false
+	}
+	if !r.consistency.TrackLSN || lsn == 0 {
+		return true
+	}
+
+	for _, rep := range r.replicas {
+		if rep.eligible() && rep.replayLSN.Load() < lsn {
+			return true
+		}
+	}
+	return false
+}
+
+// registerSession installs callbacks that record writes made in a session context.
+func (r *router) registerSession(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register("postgres:session_write", r.recordWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:commit_or_rollback_transaction").Register("postgres:session_write", r.recordWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().After("gorm:commit_or_rollback_transaction").Register("postgres:session_write", r.recordWrite); err != nil {
+		return err
+	}
+	return db.Callback().Raw().After("gorm:raw").Register("postgres:session_write", r.recordWrite)
+}
+
+func (r *router) recordWrite(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	s := sessionFrom(db.Statement.Context)
+	if s == nil {
+		return
+	}
+
+	var lsn int64
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); r.consistency.TrackLSN && !inTx {
+		// A failed lookup leaves the session pinned for the full window.
+		_ = r.masterPool.QueryRow(db.Statement.Context, _currentWALLSNQuery).Scan(&lsn)
+	}
+	s.markWrite(lsn)
+}
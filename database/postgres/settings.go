@@ -0,0 +1,254 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"maps"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"weak"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const _localTxKey = "postgres:local_transaction"
+
+type settingsKey struct{}
+
+// WithSettings returns a context whose queries run with the given run-time
+// parameters set by set_config(name, value, true), e.g. "app.tenant_id" read by
+// row-level security policies. Settings are added to those already in ctx.
+// Names must be custom parameters containing a dot.
+//
+// The settings are transaction local, so they are safe behind transaction
+// poolers. Each statement made outside a transaction runs in its own
+// transaction; Row, Rows and Scan read every row into memory before it commits.
+// Inside a transaction they are applied before its first statement, and again
+// when the settings change or after rolling back to a savepoint.
+func WithSettings(ctx context.Context, settings map[string]string) context.Context {
+	merged := maps.Clone(SettingsFrom(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(settings))
+	}
+	maps.Copy(merged, settings)
+	return context.WithValue(ctx, settingsKey{}, merged)
+}
+
+// SettingsFrom returns the settings of ctx. The map must not be modified.
+func SettingsFrom(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	settings, _ := ctx.Value(settingsKey{}).(map[string]string)
+	return settings
+}
+
+// localScope applies the tenant search_path and the context settings at the
+// start of every statement's transaction.
+type localScope struct {
+	tenant *TenantConfig
+
+	mu sync.Mutex
+	// Settings applied to open transactions, so later statements of the same
+	// transaction skip them. Entries are removed once a transaction is collected.
+	applied map[weak.Pointer[sql.Tx]]string
+}
+
+// registerLocalScope installs the callbacks applying transaction local settings.
+// They run after dbresolver and the replica router choose the connection pool,
+// and after GORM's default transaction is started.
+func registerLocalScope(db *gorm.DB, tenant *TenantConfig) error {
+	l := &localScope{tenant: tenant, applied: make(map[weak.Pointer[sql.Tx]]string)}
+	callback := db.Callback()
+
+	if err := callback.Create().After("gorm:begin_transaction").Before("gorm:before_create").Register("postgres:local_begin", l.begin); err != nil {
+		return err
+	}
+	if err := callback.Query().After("postgres:replica_router").Before("gorm:query").Register("postgres:local_begin", l.begin); err != nil {
+		return err
+	}
+	if err := callback.Update().After("gorm:begin_transaction").Before("gorm:setup_reflect_value").Register("postgres:local_begin", l.begin); err != nil {
+		return err
+	}
+	if err := callback.Delete().After("gorm:begin_transaction").Before("gorm:before_delete").Register("postgres:local_begin", l.begin); err != nil {
+		return err
+	}
+	if err := callback.Row().After("postgres:replica_router").Before("gorm:row").Register("postgres:local_begin", l.beginRow); err != nil {
+		return err
+	}
+	if err := callback.Raw().After("postgres:replica_router").Before("gorm:raw").Register("postgres:local_begin", l.begin); err != nil {
+		return err
+	}
+
+	if err := callback.Create().Register("postgres:local_end", l.end); err != nil {
+		return err
+	}
+	if err := callback.Query().Register("postgres:local_end", l.end); err != nil {
+		return err
+	}
+	if err := callback.Update().Register("postgres:local_end", l.end); err != nil {
+		return err
+	}
+	if err := callback.Delete().Register("postgres:local_end", l.end); err != nil {
+		return err
+	}
+	if err := callback.Row().After("gorm:row").Register("postgres:local_end", l.end); err != nil {
+		return err
+	}
+	return callback.Raw().Register("postgres:local_end", l.end)
+}
+
+// begin applies the settings, starting a transaction when the statement is not in one.
+func (l *localScope) begin(db *gorm.DB) {
+	// Rolling back to a savepoint may undo settings applied after it.
+	if tx, ok := db.Statement.ConnPool.(*sql.Tx); ok && isRollbackToSavepoint(db.Statement.SQL.String()) {
+		l.forget(weak.Make(tx))
+		return
+	}
+
+	settings, ok := l.settings(db)
+	if !ok {
+		return
+	}
+
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); !inTx {
+		tx := db.Begin()
+		if tx.Error != nil {
+			_ = db.AddError(errors.Wrap(tx.Error, "failed to begin transaction for local settings"))
+			return
+		}
+		db.Statement.ConnPool = tx.Statement.ConnPool
+		db.InstanceSet(_localTxKey, true)
+	}
+	l.apply(db, settings)
+}
+
+// beginRow applies the settings for statements whose rows are read after the
+// callbacks return. Outside a transaction, the rows are read into memory within
+// the local transaction, so it can commit before the caller reads them.
+func (l *localScope) beginRow(db *gorm.DB) {
+	settings, ok := l.settings(db)
+	if !ok {
+		return
+	}
+
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); !inTx {
+		tx := db.Begin()
+		if tx.Error != nil {
+			_ = db.AddError(errors.Wrap(tx.Error, "failed to begin transaction for local settings"))
+			return
+		}
+		committer, _ := tx.Statement.ConnPool.(gorm.TxCommitter)
+		db.Statement.ConnPool = &bufferedConnPool{ConnPool: tx.Statement.ConnPool, tx: committer}
+		db.InstanceSet(_localTxKey, true)
+	}
+	l.apply(db, settings)
+}
+
+// settings returns the validated settings of the statement context, ordered by name.
+func (l *localScope) settings(db *gorm.DB) ([][2]string, bool) {
+	if db.Error != nil {
+		return nil, false
+	}
+	ctx := db.Statement.Context
+	values := SettingsFrom(ctx)
+	schema, hasTenant := TenantFrom(ctx)
+	if len(values) == 0 && !hasTenant {
+		return nil, false
+	}
+
+	settings := make([][2]string, 0, len(values)+1)
+	if hasTenant {
+		if l.tenant == nil {
+			_ = db.AddError(errors.New("tenant scope is not configured"))
+			return nil, false
+		}
+		if !l.tenant.allowed(schema) {
+			_ = db.AddError(errors.Errorf("tenant schema %q is not allowed", schema))
+			return nil, false
+		}
+		settings = append(settings, [2]string{"search_path", l.tenant.searchPath(schema)})
+	}
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if !strings.Contains(name, ".") {
+			_ = db.AddError(errors.Errorf("setting %q must be a custom parameter such as app.tenant_id", name))
+			return nil, false
+		}
+		settings = append(settings, [2]string{name, values[name]})
+	}
+	return settings, true
+}
+
+// apply sets every setting with a single statement, unless the transaction
+// already has the same settings.
+func (l *localScope) apply(db *gorm.DB, settings [][2]string) {
+	calls := make([]string, len(settings))
+	args := make([]any, 0, len(settings)*2)
+	// PostgreSQL text cannot contain NUL bytes, so they separate names and values.
+	var applied strings.Builder
+	for i, setting := range settings {
+		calls[i] = "set_config($" + strconv.Itoa(2*i+1) + ", $" + strconv.Itoa(2*i+2) + ", true)"
+		args = append(args, setting[0], setting[1])
+		applied.WriteString(setting[0] + "\x00" + setting[1] + "\x00")
+	}
+	query := "SELECT " + strings.Join(calls, ", ")
+
+	tx, inTx := db.Statement.ConnPool.(*sql.Tx)
+	if inTx && l.appliedTo(tx) == applied.String() {
+		return
+	}
+	_, err := db.Statement.ConnPool.ExecContext(db.Statement.Context, query, args...)
+	if err != nil {
+		_ = db.AddError(errors.Wrap(err, "failed to apply local settings"))
+		return
+	}
+	if inTx {
+		l.record(tx, applied.String())
+	}
+}
+
+func (l *localScope) appliedTo(tx *sql.Tx) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.applied[weak.Make(tx)]
+}
+
+func (l *localScope) record(tx *sql.Tx, applied string) {
+	key := weak.Make(tx)
+	l.mu.Lock()
+	_, known := l.applied[key]
+	l.applied[key] = applied
+	l.mu.Unlock()
+	if !known {
+		runtime.AddCleanup(tx, l.forget, key)
+	}
+}
+
+func (l *localScope) forget(key weak.Pointer[sql.Tx]) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.applied, key)
+}
+
+func isRollbackToSavepoint(query string) bool {
+	fields := strings.Fields(query)
+	return len(fields) >= 2 && strings.EqualFold(fields[0], "ROLLBACK") && strings.EqualFold(fields[1], "TO")
+}
+
+// end commits or rolls back the transaction started by begin.
+func (l *localScope) end(db *gorm.DB) {
+	if _, ok := db.InstanceGet(_localTxKey); !ok {
+		return
+	}
+
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = db.ConnPool
+}
@@ -0,0 +1,99 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestLocalSettingsRowsOutsideTransaction(t *testing.T) {
+	recorder := &statementRecorder{}
+	sqlDB := sql.OpenDB(recorder)
+	defer sqlDB.Close()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm: %v", err)
+	}
+	if err := registerLocalScope(db, &TenantConfig{Schemas: []string{"acme"}}); err != nil {
+		t.Fatalf("failed to register local scope: %v", err)
+	}
+	ctx := WithTenant(context.Background(), "acme")
+
+	var ids []int64
+	if err := db.WithContext(ctx).Raw("SELECT id FROM orders").Scan(&ids).Error; err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if want := []int64{1}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Scan() ids = %v, want %v", ids, want)
+	}
+
+	var id int64
+	if err := db.WithContext(ctx).Raw("SELECT id FROM orders").Row().Scan(&id); err != nil {
+		t.Fatalf("Row().Scan() error = %v", err)
+	}
+	if id != 1 {
+		t.Errorf("Row().Scan() id = %d, want 1", id)
+	}
+
+	statements := recorder.all()
+	want := []string{"BEGIN", "SELECT set_config", "SELECT id FROM orders", "COMMIT"}
+	if len(statements) != 2*len(want) {
+		t.Fatalf("statements = %q, want %v twice", statements, want)
+	}
+	for i, statement := range statements {
+		if prefix := want[i%len(want)]; !strings.HasPrefix(statement, prefix) {
+			t.Fatalf("statements = %q, want %v twice", statements, want)
+		}
+	}
+	if inUse := sqlDB.Stats().InUse; inUse != 0 {
+		t.Errorf("connections in use = %d, want 0", inUse)
+	}
+}
+
+func TestLocalSettingsOncePerTransaction(t *testing.T) {
+	recorder := &statementRecorder{}
+	sqlDB := sql.OpenDB(recorder)
+	defer sqlDB.Close()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to open gorm: %v", err)
+	}
+	if err := registerLocalScope(db, nil); err != nil {
+		t.Fatalf("failed to register local scope: %v", err)
+	}
+	ctx := WithSettings(context.Background(), map[string]string{"app.tenant_id": "42"})
+
+	errRollback := errors.New("rollback")
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tx.Exec("UPDATE orders SET status = 'paid'")
+		tx.Exec("UPDATE orders SET status = 'shipped'")
+		// Rolling back to the savepoint may undo the settings, so they are applied again.
+		_ = tx.Transaction(func(*gorm.DB) error { return errRollback })
+		return tx.Exec("UPDATE orders SET status = 'done'").Error
+	})
+	if err != nil {
+		t.Fatalf("Transaction() error = %v", err)
+	}
+
+	statements := recorder.all()
+	want := []string{
+		"BEGIN", "SELECT set_config", "UPDATE", "UPDATE",
+		"SAVEPOINT", "ROLLBACK TO SAVEPOINT", "SELECT set_config", "UPDATE", "COMMIT",
+	}
+	if len(statements) != len(want) {
+		t.Fatalf("statements = %q, want %v", statements, want)
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(statements[i], prefix) {
+			t.Fatalf("statements = %q, want %v", statements, want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// TenantConfig defines schema-per-tenant routing for contexts created with WithTenant.
+type TenantConfig struct {
+	// Schemas tenants may use.
+	Schemas []string `json:"schemas" yaml:"schemas"`
+	// Allow accepts schemas not listed in Schemas, e.g. looked up from a tenant registry.
+	Allow func(schema string) bool `json:"-" yaml:"-"`
+	// Schemas searched after the tenant schema, e.g. "public" for shared tables and extensions.
+	SharedSchemas []string `json:"sharedSchemas" yaml:"sharedSchemas"`
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose queries run with search_path set to schema.
+// The search_path is set with SET LOCAL, so each statement made outside a
+// transaction runs in its own transaction; Row, Rows and Scan read every row
+// into memory before it commits.
+func WithTenant(ctx context.Context, schema string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, schema)
+}
+
+// TenantFrom returns the tenant schema of ctx.
+func TenantFrom(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	schema, ok := ctx.Value(tenantKey{}).(string)
+	return schema, ok
+}
+
+// allowed reports whether the tenant schema may be used.
+func (c *TenantConfig) allowed(schema string) bool {
+	if slices.Contains(c.Schemas, schema) {
+		return true
+	}
+	return c.Allow != nil && c.Allow(schema)
+}
+
+// searchPath renders the search_path of the tenant schema.
+func (c *TenantConfig) searchPath(schema string) string {
+	schemas := make([]string, 0, len(c.SharedSchemas)+1)
+	schemas = append(schemas, pgx.Identifier{schema}.Sanitize())
+	for _, shared := range c.SharedSchemas {
+		schemas = append(schemas, pgx.Identifier{shared}.Sanitize())
+	}
+	return strings.Join(schemas, ", ")
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pkg/errors"
+)
+
+// TLSConfig defines TLS settings applied to the master and every replica.
+// Certificates can be given as file paths or as inline PEM data; inline data wins.
+// Requires an SSLMode other than disable; combine with "verify-ca" or
+// "verify-full" to verify the server.
+type TLSConfig struct {
+	// CA certificates used to verify the server.
+	RootCertFile string `json:"rootCertFile" yaml:"rootCertFile"`
+	RootCertPEM  string `json:"rootCertPEM" yaml:"rootCertPEM"`
+
+	// Client certificate and key for certificate authentication.
+	CertFile string `json:"certFile" yaml:"certFile"`
+	CertPEM  string `json:"certPEM" yaml:"certPEM"`
+	KeyFile  string `json:"keyFile" yaml:"keyFile"`
+	KeyPEM   string `json:"keyPEM" yaml:"keyPEM"`
+	// Passphrase of an encrypted KeyFile; inline keys must be decrypted.
+	KeyPassword string `json:"keyPassword" yaml:"keyPassword"`
+
+	// Overrides the host name sent for SNI and checked by verify-full.
+	ServerName string `json:"serverName" yaml:"serverName"`
+}
+
+// hasInlineRootCert reports whether the root CA is provided inline.
+func (t *TLSConfig) hasInlineRootCert() bool {
+	return t != nil && t.RootCertPEM != ""
+}
+
+// hasInlineCert reports whether any part of the client certificate is provided inline.
+func (t *TLSConfig) hasInlineCert() bool {
+	return t != nil && (t.CertPEM != "" || t.KeyPEM != "")
+}
+
+// appendDSNParams renders the file based settings that pgx loads itself.
+func (t *TLSConfig) appendDSNParams(dsn *strings.Builder) {
+	if t == nil {
+		return
+	}
+	if t.RootCertFile != "" && !t.hasInlineRootCert() {
+		appendDSNParam(dsn, "sslrootcert", t.RootCertFile)
+	}
+	if t.CertFile != "" && t.KeyFile != "" && !t.hasInlineCert() {
+		appendDSNParam(dsn, "sslcert", t.CertFile)
+		appendDSNParam(dsn, "sslkey", t.KeyFile)
+		if t.KeyPassword != "" {
+			appendDSNParam(dsn, "sslpassword", t.KeyPassword)
+		}
+	}
+}
+
+// validate rejects settings that would otherwise be ignored or fail obscurely.
+func (t *TLSConfig) validate(sslMode string) error {
+	if sslMode == "" || sslMode == "disable" {
+		return errors.New("TLS settings require an sslMode other than disable")
+	}
+	hasCert := t.CertPEM != "" || t.CertFile != ""
+	hasKey := t.KeyPEM != "" || t.KeyFile != ""
+	if hasCert != hasKey {
+		return errors.New("TLS client certificate and key must be set together")
+	}
+	// Inline certificates are loaded with crypto/tls, which cannot decrypt keys.
+	if t.KeyPassword != "" && t.hasInlineCert() {
+		return errors.New("TLS keyPassword is only supported with certFile and keyFile; decrypt inline keys beforehand")
+	}
+	return nil
+}
+
+// applyTLSConfig applies inline certificates and the server name override
+// to the primary host and every fallback host.
+func applyTLSConfig(cfg *pgx.ConnConfig, t *TLSConfig, sslMode string) error {
+	if t == nil {
+		return nil
+	}
+	if err := t.validate(sslMode); err != nil {
+		return err
+	}
+
+	var rootCAs *x509.CertPool
+	if t.hasInlineRootCert() {
+		rootCAs = x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM([]byte(t.RootCertPEM)) {
+			return errors.New("failed to parse root certificate PEM")
+		}
+	}
+
+	var certificates []tls.Certificate
+	if t.hasInlineCert() {
+		certPEM, err := readPEM(t.CertPEM, t.CertFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read client certificate")
+		}
+		keyPEM, err := readPEM(t.KeyPEM, t.KeyFile)
+		if err != nil {
+			return errors.Wrap(err, "failed to read client key")
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return errors.Wrap(err, "failed to load client certificate")
+		}
+		certificates = []tls.Certificate{cert}
+	}
+
+	tlsConfigs := []*tls.Config{cfg.TLSConfig}
+	for _, fallback := range cfg.Fallbacks {
+		tlsConfigs = append(tlsConfigs, fallback.TLSConfig)
+	}
+	for _, tlsConfig := range tlsConfigs {
+		// A nil config is the plaintext attempt of sslmode=allow/prefer.
+		if tlsConfig == nil {
+			continue
+		}
+		if rootCAs != nil {
+			tlsConfig.RootCAs = rootCAs
+		}
+		if certificates != nil {
+			tlsConfig.Certificates = certificates
+		}
+		if t.ServerName != "" {
+			tlsConfig.ServerName = t.ServerName
+		}
+	}
+
+	return nil
+}
+
+func readPEM(inline, file string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if file == "" {
+		return nil, errors.New("missing PEM data")
+	}
+	return os.ReadFile(file)
+}
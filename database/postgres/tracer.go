@@ -0,0 +1,195 @@
+package postgres
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// newTracer combines tracers into the single tracer pgx accepts; nil when there are none.
+func newTracer(tracers []pgx.QueryTracer) pgx.QueryTracer {
+	switch len(tracers) {
+	case 0:
+		return nil
+	case 1:
+		return tracers[0]
+	}
+	return multitracer.New(tracers...)
+}
+
+// sqlState returns the SQLSTATE of err, or "" when it did not come from the server.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// SlogTracerConfig defines how SlogTracer logs.
+type SlogTracerConfig struct {
+	// Logger defaults to slog.Default().
+	Logger *slog.Logger
+	// Level of successful operations; failures are logged at slog.LevelError.
+	// Defaults to slog.LevelDebug.
+	Level *slog.Level
+	// LogArgs logs bind arguments, which may hold personal data or secrets.
+	// They are redacted unless set.
+	LogArgs bool
+}
+
+// SlogTracer logs queries, batches, copies, connects and pool acquisitions with
+// their duration, row count and SQLSTATE.
+type SlogTracer struct {
+	logger  *slog.Logger
+	level   slog.Level
+	logArgs bool
+}
+
+// NewSlogTracer creates a tracer for DBConn.Tracers writing to slog.
+func NewSlogTracer(cfg SlogTracerConfig) *SlogTracer {
+	t := &SlogTracer{
+		logger:  cfg.Logger,
+		level:   slog.LevelDebug,
+		logArgs: cfg.LogArgs,
+	}
+	if t.logger == nil {
+		t.logger = slog.Default()
+	}
+	if cfg.Level != nil {
+		t.level = *cfg.Level
+	}
+	return t
+}
+
+type slogTraceKey struct{}
+
+// slogTrace carries the start of an operation to its end.
+type slogTrace struct {
+	start time.Time
+	// End of the previous query of a batch, where the next one starts.
+	last  time.Time
+	attrs []slog.Attr
+}
+
+func (t *SlogTracer) start(ctx context.Context, attrs ...slog.Attr) context.Context {
+	now := time.Now()
+	return context.WithValue(ctx, slogTraceKey{}, &slogTrace{start: now, last: now, attrs: attrs})
+}
+
+func (t *SlogTracer) end(ctx context.Context, msg string, err error, attrs ...slog.Attr) {
+	trace, _ := ctx.Value(slogTraceKey{}).(*slogTrace)
+	var duration time.Duration
+	if trace != nil {
+		duration = time.Since(trace.start)
+	}
+	t.log(ctx, msg, err, trace, duration, attrs)
+}
+
+func (t *SlogTracer) log(ctx context.Context, msg string, err error, trace *slogTrace, duration time.Duration, attrs []slog.Attr) {
+	level := t.level
+	if err != nil {
+		level = slog.LevelError
+	}
+	if !t.logger.Enabled(ctx, level) {
+		return
+	}
+
+	if trace != nil {
+		attrs = append(attrs, slog.Duration("duration", duration))
+		attrs = append(attrs, trace.attrs...)
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		if code := sqlState(err); code != "" {
+			attrs = append(attrs, slog.String("sqlstate", code))
+		}
+	}
+	t.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (t *SlogTracer) args(args []any) slog.Attr {
+	if t.logArgs {
+		return slog.Any("args", args)
+	}
+	if len(args) == 0 {
+		return slog.Attr{}
+	}
+	return slog.String("args", "[REDACTED]")
+}
+
+func connAttr(conn *pgx.Conn) slog.Attr {
+	if conn == nil || conn.PgConn() == nil {
+		return slog.Attr{}
+	}
+	return slog.Uint64("pid", uint64(conn.PgConn().PID()))
+}
+
+// TraceQueryStart implements pgx.QueryTracer.
+func (t *SlogTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return t.start(ctx, slog.String("sql", data.SQL), t.args(data.Args), connAttr(conn))
+}
+
+// TraceQueryEnd implements pgx.QueryTracer.
+func (t *SlogTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	t.end(ctx, "query", data.Err, slog.Int64("rows", data.CommandTag.RowsAffected()))
+}
+
+// TraceBatchStart implements pgx.BatchTracer.
+func (t *SlogTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	return t.start(ctx, slog.Int("size", data.Batch.Len()), connAttr(conn))
+}
+
+// TraceBatchQuery implements pgx.BatchTracer. Queries of a batch are pipelined,
+// so each duration runs from the end of the previous query, or the batch start.
+func (t *SlogTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	trace, _ := ctx.Value(slogTraceKey{}).(*slogTrace)
+	var duration time.Duration
+	if trace != nil {
+		now := time.Now()
+		duration, trace.last = now.Sub(trace.last), now
+	}
+	t.log(ctx, "batch query", data.Err, trace, duration,
+		[]slog.Attr{slog.String("sql", data.SQL), t.args(data.Args), slog.Int64("rows", data.CommandTag.RowsAffected())})
+}
+
+// TraceBatchEnd implements pgx.BatchTracer.
+func (t *SlogTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	t.end(ctx, "batch", data.Err)
+}
+
+// TraceCopyFromStart implements pgx.CopyFromTracer.
+func (t *SlogTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	return t.start(ctx, slog.String("table", data.TableName.Sanitize()), slog.Any("columns", data.ColumnNames), connAttr(conn))
+}
+
+// TraceCopyFromEnd implements pgx.CopyFromTracer.
+func (t *SlogTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	t.end(ctx, "copy from", data.Err, slog.Int64("rows", data.CommandTag.RowsAffected()))
+}
+
+// TraceConnectStart implements pgx.ConnectTracer.
+func (t *SlogTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	return t.start(ctx, slog.String("host", data.ConnConfig.Host), slog.Uint64("port", uint64(data.ConnConfig.Port)))
+}
+
+// TraceConnectEnd implements pgx.ConnectTracer.
+func (t *SlogTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	t.end(ctx, "connect", data.Err, connAttr(data.Conn))
+}
+
+// TraceAcquireStart implements pgxpool.AcquireTracer.
+func (t *SlogTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	return t.start(ctx)
+}
+
+// TraceAcquireEnd implements pgxpool.AcquireTracer.
+func (t *SlogTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	t.end(ctx, "acquire", data.Err, connAttr(data.Conn))
+}
@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"math/rand/v2"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const (
+	_defaultTxMaxAttempts    = 5
+	_defaultTxInitialBackoff = 10 * time.Millisecond
+	_defaultTxMaxBackoff     = time.Second
+)
+
+// TxOptions configures RunInTx.
+type TxOptions struct {
+	// Isolation level; sql.LevelDefault uses the server default.
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+	// Total number of attempts, including the first one; defaults to 5.
+	MaxAttempts int
+	// Backoff before the second attempt, doubled after every retry up to MaxBackoff.
+	// Each wait is randomly jittered between zero and the current backoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// RunInTx runs fn in a transaction and retries the whole transaction on
+// serialization failures (40001) and deadlocks (40P01).
+// fn may run several times, so it must not have side effects outside the transaction.
+// db must not already be inside a transaction.
+func RunInTx(ctx context.Context, db *gorm.DB, opts TxOptions, fn func(tx *gorm.DB) error) error {
+	maxAttempts := firstPositive(opts.MaxAttempts, _defaultTxMaxAttempts)
+	backoff := firstPositive(opts.InitialBackoff, _defaultTxInitialBackoff)
+	maxBackoff := firstPositive(opts.MaxBackoff, _defaultTxMaxBackoff)
+	txOptions := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+
+	for attempt := 1; ; attempt++ {
+		err := db.WithContext(ctx).Transaction(fn, txOptions)
+		if err == nil || attempt >= maxAttempts || !isRetryableTxError(err) {
+			return err
+		}
+
+		wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "transaction retry aborted after %v", err)
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// isRetryableTxError reports whether the transaction can be retried as a whole.
+func isRetryableTxError(err error) bool {
+	return IsSerializationFailure(err) || IsDeadlock(err)
+}
@@ -0,0 +1,294 @@
+package postgres
+
+import (
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ParseURL creates a DBConn from a postgres:// or postgresql:// connection URI.
+// Multiple hosts ("host1:5432,host2:5433") fill Master.Host and Master.Hosts;
+// Unix socket directories are read from the host parameter, which replaces the
+// hosts of the authority, or from the percent-encoded authority. The port
+// parameter holds a single port or one port per host.
+// Query parameters without a matching DBConn field are kept in RuntimeParams.
+func ParseURL(rawURL string) (*DBConn, error) {
+	// net/url rejects comma separated hosts, so parse them separately.
+	hosts, rawURL := splitURLHosts(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse url")
+	}
+	if u.Scheme != "postgres" && u.Scheme != "postgresql" {
+		return nil, errors.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	// Socket directories are percent-encoded in the authority, e.g. %2Fvar%2Frun%2Fpostgresql.
+	if hosts, err = url.PathUnescape(hosts); err != nil {
+		return nil, errors.Wrap(err, "failed to parse url hosts")
+	}
+
+	conn := &DBConn{
+		Database: strings.TrimPrefix(u.Path, "/"),
+	}
+
+	if u.User != nil {
+		conn.Master.UserName = u.User.Username()
+		conn.Master.Password, _ = u.User.Password()
+	}
+
+	conn.Master.setHosts(hosts)
+
+	var hostParam, portParam string
+	for key, values := range u.Query() {
+		value := values[len(values)-1]
+		switch key {
+		case "host":
+			hostParam = value
+		case "port":
+			portParam = value
+		case "user":
+			conn.Master.UserName = value
+		case "password":
+			conn.Master.Password = value
+		case "dbname":
+			conn.Database = value
+		case "sslmode":
+			conn.SSLMode = value
+		case "sslrootcert":
+			conn.tlsConfig().RootCertFile = value
+		case "sslcert":
+			conn.tlsConfig().CertFile = value
+		case "sslkey":
+			conn.tlsConfig().KeyFile = value
+		case "sslpassword":
+			conn.tlsConfig().KeyPassword = value
+		case "search_path":
+			conn.SearchPath = value
+		case "application_name":
+			conn.ApplicationName = value
+		case "target_session_attrs":
+			conn.Master.TargetSessionAttrs = value
+		case "statement_timeout":
+			if conn.StatementTimeout, err = parseTimeout(key, value); err != nil {
+				return nil, err
+			}
+		case "lock_timeout":
+			if conn.LockTimeout, err = parseTimeout(key, value); err != nil {
+				return nil, err
+			}
+		case "idle_in_transaction_session_timeout":
+			if conn.IdleInTransactionSessionTimeout, err = parseTimeout(key, value); err != nil {
+				return nil, err
+			}
+		default:
+			if conn.RuntimeParams == nil {
+				conn.RuntimeParams = make(map[string]string)
+			}
+			conn.RuntimeParams[key] = value
+		}
+	}
+
+	// The host parameter replaces the hosts of the authority, as in libpq.
+	if hostParam != "" {
+		conn.Master.Host, conn.Master.Hosts = "", nil
+		conn.Master.setHosts(hostParam)
+	}
+	if portParam != "" {
+		if err := conn.Master.setPorts(portParam); err != nil {
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// URL renders the master connection as a postgres:// URI.
+// Only fields that are set are rendered, so the result round-trips through ParseURL.
+func (c *DBConn) URL() string {
+	u := url.URL{
+		Scheme: "postgres",
+		Path:   "/" + c.Database,
+	}
+
+	if c.Master.UserName != "" || c.Master.Password != "" {
+		if c.Master.Password != "" {
+			u.User = url.UserPassword(c.Master.UserName, c.Master.Password)
+		} else {
+			u.User = url.User(c.Master.UserName)
+		}
+	}
+
+	query := make(url.Values, len(c.RuntimeParams)+8)
+	for key, value := range c.RuntimeParams {
+		query.Set(key, value)
+	}
+
+	hosts := append([]string{c.Master.Host}, c.Master.Hosts...)
+	if slices.ContainsFunc(hosts, isSocketHost) {
+		// Socket directories cannot appear in the authority, so use parameters as libpq does.
+		hostNames, ports := c.Master.hostPorts()
+		query.Set("host", strings.Join(hostNames, ","))
+		if slices.ContainsFunc(ports, func(port string) bool { return port != c.Master.Port }) {
+			query.Set("port", strings.Join(ports, ","))
+		} else if c.Master.Port != "" {
+			query.Set("port", c.Master.Port)
+		}
+	} else {
+		hosts[0] = bracketHost(c.Master.Host)
+		if c.Master.Port != "" {
+			hosts[0] = net.JoinHostPort(c.Master.Host, c.Master.Port)
+		}
+		for i, candidate := range c.Master.Hosts {
+			if _, _, err := net.SplitHostPort(candidate); err != nil {
+				hosts[i+1] = bracketHost(candidate)
+			}
+		}
+		u.Host = strings.Join(hosts, ",")
+	}
+	setParam := func(key, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	setParam("sslmode", c.SSLMode)
+	if c.TLS != nil {
+		setParam("sslrootcert", c.TLS.RootCertFile)
+		setParam("sslcert", c.TLS.CertFile)
+		setParam("sslkey", c.TLS.KeyFile)
+		setParam("sslpassword", c.TLS.KeyPassword)
+	}
+	setParam("search_path", c.SearchPath)
+	setParam("application_name", c.ApplicationName)
+	setParam("target_session_attrs", c.Master.TargetSessionAttrs)
+	if c.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.FormatInt(c.StatementTimeout.Milliseconds(), 10))
+	}
+	if c.LockTimeout > 0 {
+		query.Set("lock_timeout", strconv.FormatInt(c.LockTimeout.Milliseconds(), 10))
+	}
+	if c.IdleInTransactionSessionTimeout > 0 {
+		query.Set("idle_in_transaction_session_timeout", strconv.FormatInt(c.IdleInTransactionSessionTimeout.Milliseconds(), 10))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// setHosts fills Host, Port and Hosts from a comma separated host list.
+// Brackets around IPv6 addresses without a port are removed.
+func (c *ConnectionConfig) setHosts(hosts string) {
+	for i, candidate := range strings.Split(hosts, ",") {
+		if candidate == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(candidate)
+		if err != nil {
+			host, port = strings.TrimSuffix(strings.TrimPrefix(candidate, "["), "]"), ""
+		}
+		if i > 0 {
+			if port == "" {
+				candidate = host
+			}
+			c.Hosts = append(c.Hosts, candidate)
+			continue
+		}
+		c.Host = host
+		if port != "" {
+			c.Port = port
+		}
+	}
+}
+
+// setPorts applies a port parameter: a single port for every host, or a comma
+// separated list with one port per host. Empty entries keep the default port.
+func (c *ConnectionConfig) setPorts(ports string) error {
+	list := strings.Split(ports, ",")
+	if len(list) == 1 {
+		c.Port = list[0]
+		return nil
+	}
+	if len(list) != 1+len(c.Hosts) {
+		return errors.Errorf("port list has %d entries for %d hosts", len(list), 1+len(c.Hosts))
+	}
+
+	c.Port = list[0]
+	for i, candidate := range c.Hosts {
+		// Hosts without a port use Port, so only differing ports are kept.
+		if port := list[i+1]; port != "" && port != c.Port {
+			c.Hosts[i] = net.JoinHostPort(candidate, port)
+		}
+	}
+	return nil
+}
+
+// hostPorts returns every host without its port, and the port of each host.
+func (c *ConnectionConfig) hostPorts() (hosts, ports []string) {
+	hosts, ports = []string{c.Host}, []string{c.Port}
+	for _, candidate := range c.Hosts {
+		host, port, err := net.SplitHostPort(candidate)
+		if err != nil {
+			host, port = candidate, c.Port
+		}
+		hosts = append(hosts, host)
+		ports = append(ports, port)
+	}
+	return hosts, ports
+}
+
+// isSocketHost reports whether host is a Unix socket directory.
+func isSocketHost(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
+
+// bracketHost encloses IPv6 addresses in brackets for use in a URL authority.
+func bracketHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// splitURLHosts removes the host list from rawURL, leaving a single placeholder host.
+func splitURLHosts(rawURL string) (hosts, rest string) {
+	schemeEnd := strings.Index(rawURL, "://")
+	if schemeEnd == -1 {
+		return "", rawURL
+	}
+	authorityStart := schemeEnd + len("://")
+	authorityEnd := len(rawURL)
+	if i := strings.IndexAny(rawURL[authorityStart:], "/?#"); i != -1 {
+		authorityEnd = authorityStart + i
+	}
+
+	hostsStart := authorityStart
+	if i := strings.LastIndex(rawURL[authorityStart:authorityEnd], "@"); i != -1 {
+		hostsStart = authorityStart + i + 1
+	}
+	return rawURL[hostsStart:authorityEnd], rawURL[:hostsStart] + "placeholder" + rawURL[authorityEnd:]
+}
+
+// tlsConfig returns the TLS settings, allocating them when missing.
+func (c *DBConn) tlsConfig() *TLSConfig {
+	if c.TLS == nil {
+		c.TLS = &TLSConfig{}
+	}
+	return c.TLS
+}
+
+// parseTimeout parses a timeout given in milliseconds, as PostgreSQL does,
+// or as a Go duration string such as "5s".
+func parseTimeout(key, value string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", key)
+	}
+	return d, nil
+}
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	_defaultVerifyTimeout        = 5 * time.Second
+	_defaultVerifyAttempts       = 3
+	_defaultVerifyInitialBackoff = 500 * time.Millisecond
+	_defaultVerifyMaxBackoff     = 5 * time.Second
+)
+
+// VerifyConfig defines how endpoints are checked for connectivity.
+type VerifyConfig struct {
+	// Timeout of a single ping attempt.
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+	// Number of ping attempts per endpoint.
+	Attempts int `json:"attempts" yaml:"attempts"`
+	// Backoff before the second attempt; doubled after every failure up to MaxBackoff.
+	InitialBackoff time.Duration `json:"initialBackoff" yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `json:"maxBackoff" yaml:"maxBackoff"`
+}
+
+// endpoint is a single master or replica pool owned by a client.
+type endpoint struct {
+	role  Role
+	index int
+	host  string
+	pool  *pgxpool.Pool
+	// database/sql wrapper used by GORM; nil until GORM is opened.
+	db *sql.DB
+	// Open connections of pool, closed by Client.Close after its deadline.
+	conns *connTracker
+}
+
+// EndpointError reports a failure of a single endpoint.
+type EndpointError struct {
+	Role Role
+	// Index of the replica in DBConn.EffectiveReplicas; -1 for the master.
+	Index int
+	Host  string
+	Err   error
+}
+
+func (e *EndpointError) Error() string {
+	if e.Role == RoleReplica {
+		return fmt.Sprintf("%s %d (%s): %v", e.Role, e.Index, e.Host, e.Err)
+	}
+	return fmt.Sprintf("%s (%s): %v", e.Role, e.Host, e.Err)
+}
+
+func (e *EndpointError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyError lists every endpoint that failed connectivity verification.
+type VerifyError struct {
+	Endpoints []*EndpointError
+}
+
+func (e *VerifyError) Error() string {
+	return "failed to verify endpoints: " + joinEndpointErrors(e.Endpoints)
+}
+
+// Unwrap exposes the endpoint errors to errors.Is and errors.As.
+func (e *VerifyError) Unwrap() []error {
+	return unwrapEndpointErrors(e.Endpoints)
+}
+
+func joinEndpointErrors(endpointErrs []*EndpointError) string {
+	msgs := make([]string, len(endpointErrs))
+	for i, endpointErr := range endpointErrs {
+		msgs[i] = endpointErr.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func unwrapEndpointErrors(endpointErrs []*EndpointError) []error {
+	errs := make([]error, len(endpointErrs))
+	for i, endpointErr := range endpointErrs {
+		errs[i] = endpointErr
+	}
+	return errs
+}
+
+// Verify pings the master and every replica, retrying with exponential backoff.
+// It returns a *VerifyError naming each endpoint that never answered.
+func (c *Client) Verify(ctx context.Context, cfg VerifyConfig) error {
+	timeout := firstPositive(cfg.Timeout, _defaultVerifyTimeout)
+	attempts := firstPositive(cfg.Attempts, _defaultVerifyAttempts)
+	initialBackoff := firstPositive(cfg.InitialBackoff, _defaultVerifyInitialBackoff)
+	maxBackoff := firstPositive(cfg.MaxBackoff, _defaultVerifyMaxBackoff)
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]*EndpointError, len(c.endpoints))
+	)
+	for i, ep := range c.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			backoff := initialBackoff
+			var err error
+			for attempt := 1; attempt <= attempts; attempt++ {
+				pingCtx, cancel := context.WithTimeout(ctx, timeout)
+				err = ep.pool.Ping(pingCtx)
+				cancel()
+				if err == nil || attempt == attempts {
+					break
+				}
+
+				select {
+				case <-ctx.Done():
+					err = ctx.Err()
+				case <-time.After(backoff):
+				}
+				if ctx.Err() != nil {
+					break
+				}
+				backoff = min(backoff*2, maxBackoff)
+			}
+
+			if err != nil {
+				results[i] = &EndpointError{Role: ep.role, Index: ep.index, Host: ep.host, Err: err}
+			}
+		}()
+	}
+	wg.Wait()
+
+	verifyErr := &VerifyError{}
+	for _, endpointErr := range results {
+		if endpointErr != nil {
+			verifyErr.Endpoints = append(verifyErr.Endpoints, endpointErr)
+		}
+	}
+	if len(verifyErr.Endpoints) > 0 {
+		return verifyErr
+	}
+	return nil
+}